@@ -62,3 +62,13 @@ func (m *Repo) Boilerplate(
 		WithExec([]string{"boilerplate", "--template-url", "/template", "--output-folder", outputFolder, "--var", "ServerName=MyServer", "--non-interactive"}).
 		Directory("/work/" + outputFolder)
 }
+
+// TrivyConfig scans the fixtures/terraform directory for misconfigurations
+func (m *Repo) TrivyConfig(ctx context.Context) (string, error) {
+	return dag.Trivy().ScanConfig(m.Src.Directory("fixtures/terraform")).Contents(ctx)
+}
+
+// TrivyDependencies scans the fixtures/miele-delay-start Node dependencies for vulnerabilities
+func (m *Repo) TrivyDependencies(ctx context.Context) (string, error) {
+	return dag.Trivy().ScanDirectory(m.Src.Directory("fixtures/miele-delay-start")).Contents(ctx)
+}
@@ -23,8 +23,12 @@ type Alpine struct {
 }
 
 // Base returns a base Alpine Linux container
-func (m *Alpine) Base() *dagger.Container {
-	return dag.Container().
+func (m *Alpine) Base(
+	// +optional
+	// Platform of the container, defaults to the engine platform
+	platform dagger.Platform,
+) *dagger.Container {
+	return dag.Container(dagger.ContainerOpts{Platform: platform}).
 		From(fmt.Sprintf("alpine:%s", m.ImageTag)).
 		WithoutEntrypoint()
 }
@@ -32,5 +36,5 @@ func (m *Alpine) Base() *dagger.Container {
 // WithPackages returns a container with the specified packages installed
 func (m *Alpine) WithPackages(packages []string) *dagger.Container {
 	args := append([]string{"apk", "add", "--no-cache"}, packages...)
-	return m.Base().WithExec(args)
+	return m.Base("").WithExec(args)
 }
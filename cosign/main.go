@@ -0,0 +1,353 @@
+// A Dagger module for cosign image signing and attestation
+//
+// Signs images and attaches in-toto attestations with a cosign key pair, and
+// verifies them. With offline enabled nothing is uploaded to the Rekor
+// transparency log, so signing works air-gapped against a local registry.
+//
+// Use WithRegistryAuth to authenticate against one or more private registries.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"dagger/cosign/internal/dagger"
+)
+
+func New(
+	// renovate: datasource=docker depName=ghcr.io/sigstore/cosign/cosign
+	// +default="v2.5.3"
+	imageTag string,
+	// +optional
+	// Skip the Rekor transparency log (key-pair signing only)
+	offline bool,
+	// +optional
+	// Allow insecure and plain HTTP registries (e.g., a local registry:2 service)
+	insecure bool,
+) *Cosign {
+	return &Cosign{
+		ImageTag: imageTag,
+		Offline:  offline,
+		Insecure: insecure,
+	}
+}
+
+type Cosign struct {
+	ImageTag      string
+	Offline       bool
+	Insecure      bool
+	RegistryAuths []*RegistryAuth
+	Services      []*ServiceBinding
+}
+
+// RegistryAuth holds credentials for a single registry
+type RegistryAuth struct {
+	// Registry host (e.g., "ghcr.io")
+	Registry string
+	// Registry username
+	Username string
+	// Registry password or token
+	Password *dagger.Secret
+}
+
+// ServiceBinding is a service reachable from the cosign container
+type ServiceBinding struct {
+	// Hostname the service is reachable at
+	Alias string
+	// Service to bind (e.g., a registry:2 container)
+	Service *dagger.Service
+}
+
+// KeyPair is a cosign key pair
+type KeyPair struct {
+	// Encrypted private key (cosign.key)
+	PrivateKey *dagger.Secret
+	// Public key (cosign.pub)
+	PublicKey *dagger.File
+}
+
+// WithRegistryAuth adds credentials for a registry
+//
+// Can be called several times to authenticate against multiple registries.
+func (m *Cosign) WithRegistryAuth(
+	// Registry host (e.g., "ghcr.io")
+	registry string,
+	// Registry username
+	username string,
+	// Registry password or token
+	password *dagger.Secret,
+) *Cosign {
+	m.RegistryAuths = append(m.RegistryAuths, &RegistryAuth{
+		Registry: registry,
+		Username: username,
+		Password: password,
+	})
+	return m
+}
+
+// WithServiceBinding makes a service (e.g., a local registry) reachable under the given hostname
+func (m *Cosign) WithServiceBinding(
+	// Hostname the service is reachable at (e.g., "registry")
+	alias string,
+	// Service to bind
+	service *dagger.Service,
+) *Cosign {
+	m.Services = append(m.Services, &ServiceBinding{
+		Alias:   alias,
+		Service: service,
+	})
+	return m
+}
+
+// Base returns the base container with cosign installed
+func (m *Cosign) Base() *dagger.Container {
+	ctr := dag.Container().
+		From(fmt.Sprintf("ghcr.io/sigstore/cosign/cosign:%s", m.ImageTag)).
+		WithoutEntrypoint().
+		// Never prompt for confirmation
+		WithEnvVariable("COSIGN_YES", "true")
+
+	for _, binding := range m.Services {
+		ctr = ctr.WithServiceBinding(binding.Alias, binding.Service)
+	}
+
+	return ctr
+}
+
+// authenticated returns the base container with a docker config for all registry credentials
+func (m *Cosign) authenticated() *dagger.Container {
+	config := dag.DockerConfig()
+	for _, a := range m.RegistryAuths {
+		config = config.WithRegistryAuth(a.Registry, a.Username, a.Password)
+	}
+
+	return config.Mount(m.Base().WithEnvVariable("CACHEBUSTER", time.Now().String()))
+}
+
+// withKey mounts a private key and its password
+func withKey(ctx context.Context, ctr *dagger.Container, key, password *dagger.Secret) (*dagger.Container, error) {
+	// The cosign image runs as a nonroot user, which cannot read root-owned secrets
+	user, err := ctr.User(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ctr = ctr.WithMountedSecret("/cosign/cosign.key", key, dagger.ContainerWithMountedSecretOpts{
+		Owner: user,
+	})
+	if password == nil {
+		// An unset password makes cosign prompt for one
+		return ctr.WithEnvVariable("COSIGN_PASSWORD", ""), nil
+	}
+	return ctr.WithSecretVariable("COSIGN_PASSWORD", password), nil
+}
+
+// registryFlags returns the flags of every command talking to a registry
+func (m *Cosign) registryFlags() []string {
+	if m.Insecure {
+		return []string{"--allow-insecure-registry", "--allow-http-registry"}
+	}
+	return nil
+}
+
+// signFlags returns the flags shared by sign and attest
+func (m *Cosign) signFlags() []string {
+	var flags []string
+	if m.Offline {
+		flags = append(flags, "--tlog-upload=false")
+	}
+	return append(flags, m.registryFlags()...)
+}
+
+// verifyFlags returns the flags shared by verify and verify-attestation
+func (m *Cosign) verifyFlags() []string {
+	var flags []string
+	if m.Offline {
+		flags = append(flags, "--insecure-ignore-tlog=true")
+	}
+	return append(flags, m.registryFlags()...)
+}
+
+// triangulate returns the command printing the reference cosign stores an artifact of the image at
+func (m *Cosign) triangulate(image string, flags ...string) []string {
+	args := append([]string{"cosign", "triangulate"}, flags...)
+	args = append(args, m.registryFlags()...)
+	return append(args, image)
+}
+
+// GenerateKeyPair generates a new cosign key pair
+//
+// Example: cosign generate-key-pair
+func (m *Cosign) GenerateKeyPair(
+	ctx context.Context,
+	// +optional
+	// Password encrypting the private key
+	password *dagger.Secret,
+) (*KeyPair, error) {
+	ctr := m.Base().
+		WithEnvVariable("CACHEBUSTER", time.Now().String())
+
+	// generate-key-pair writes to the working directory as the image user
+	user, err := ctr.User(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctr = ctr.
+		WithDirectory("/keys", dag.Directory(), dagger.ContainerWithDirectoryOpts{Owner: user}).
+		WithWorkdir("/keys")
+
+	if password == nil {
+		ctr = ctr.WithEnvVariable("COSIGN_PASSWORD", "")
+	} else {
+		ctr = ctr.WithSecretVariable("COSIGN_PASSWORD", password)
+	}
+
+	ctr = ctr.WithExec([]string{"cosign", "generate-key-pair"})
+
+	privateKey, err := ctr.File("/keys/cosign.key").Contents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256([]byte(privateKey))
+	return &KeyPair{
+		PrivateKey: dag.SetSecret("cosign-key-"+hex.EncodeToString(sum[:8]), privateKey),
+		PublicKey:  ctr.File("/keys/cosign.pub"),
+	}, nil
+}
+
+// Sign signs an image with a private key and pushes the signature to the registry
+//
+// Returns the reference of the signature.
+//
+// Example: cosign sign --key cosign.key registry/repo@sha256:...
+func (m *Cosign) Sign(
+	ctx context.Context,
+	// Image reference, ideally pinned by digest
+	image string,
+	// Private key (cosign.key)
+	key *dagger.Secret,
+	// +optional
+	// Password of the private key
+	password *dagger.Secret,
+	// +optional
+	// Signature annotations in key=value form
+	annotations []string,
+) (string, error) {
+	args := []string{"cosign", "sign", "--key", "/cosign/cosign.key"}
+	args = append(args, m.signFlags()...)
+	for _, annotation := range annotations {
+		args = append(args, "--annotations", annotation)
+	}
+	args = append(args, image)
+
+	ctr, err := withKey(ctx, m.authenticated(), key, password)
+	if err != nil {
+		return "", err
+	}
+
+	out, err := ctr.
+		WithExec(args).
+		WithExec(m.triangulate(image)).
+		Stdout(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(out), nil
+}
+
+// Verify verifies the signatures of an image with a public key
+//
+// Fails if no valid signature is found. Returns the verified signature payloads as JSON.
+//
+// Example: cosign verify --key cosign.pub registry/repo@sha256:...
+func (m *Cosign) Verify(
+	ctx context.Context,
+	// Image reference
+	image string,
+	// Public key (cosign.pub)
+	publicKey *dagger.File,
+) (string, error) {
+	args := []string{"cosign", "verify", "--key", "/cosign/cosign.pub", "--output", "json"}
+	args = append(args, m.verifyFlags()...)
+	args = append(args, image)
+
+	return m.authenticated().
+		WithMountedFile("/cosign/cosign.pub", publicKey).
+		WithExec(args).
+		Stdout(ctx)
+}
+
+// Attest attaches a signed in-toto attestation to an image
+//
+// Returns the reference of the attestation.
+//
+// Example: cosign attest --key cosign.key --predicate provenance.json --type slsaprovenance registry/repo@sha256:...
+func (m *Cosign) Attest(
+	ctx context.Context,
+	// Image reference, ideally pinned by digest
+	image string,
+	// Predicate of the in-toto statement (e.g., a SLSA provenance predicate)
+	predicate *dagger.File,
+	// Private key (cosign.key)
+	key *dagger.Secret,
+	// Predicate type: a cosign shorthand (slsaprovenance, slsaprovenance1, spdxjson, cyclonedx, vuln, custom) or a URI
+	// +default="slsaprovenance1"
+	predicateType string,
+	// +optional
+	// Password of the private key
+	password *dagger.Secret,
+) (string, error) {
+	args := []string{"cosign", "attest", "--key", "/cosign/cosign.key",
+		"--predicate", "/cosign/predicate.json", "--type", predicateType}
+	args = append(args, m.signFlags()...)
+	args = append(args, image)
+
+	ctr, err := withKey(ctx, m.authenticated(), key, password)
+	if err != nil {
+		return "", err
+	}
+
+	out, err := ctr.
+		WithMountedFile("/cosign/predicate.json", predicate).
+		WithExec(args).
+		WithExec(m.triangulate(image, "--type", "attestation")).
+		Stdout(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(out), nil
+}
+
+// VerifyAttestation verifies the attestations of an image with a public key
+//
+// Fails if no valid attestation of the given type is found. Returns the
+// verified attestations as JSON, one DSSE envelope per line.
+//
+// Example: cosign verify-attestation --key cosign.pub --type slsaprovenance registry/repo@sha256:...
+func (m *Cosign) VerifyAttestation(
+	ctx context.Context,
+	// Image reference
+	image string,
+	// Public key (cosign.pub)
+	publicKey *dagger.File,
+	// Predicate type: a cosign shorthand (slsaprovenance, slsaprovenance1, spdxjson, cyclonedx, vuln, custom) or a URI
+	// +default="slsaprovenance1"
+	predicateType string,
+) (string, error) {
+	args := []string{"cosign", "verify-attestation", "--key", "/cosign/cosign.pub", "--type", predicateType}
+	args = append(args, m.verifyFlags()...)
+	args = append(args, image)
+
+	return m.authenticated().
+		WithMountedFile("/cosign/cosign.pub", publicKey).
+		WithExec(args).
+		Stdout(ctx)
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ImageDiff describes what changed between two images
+type ImageDiff struct {
+	// Old image reference
+	From string
+	// New image reference
+	To string
+	// Platforms only present in the new image
+	AddedPlatforms []string
+	// Platforms only present in the old image
+	RemovedPlatforms []string
+	// Changes for each platform present in both images
+	Platforms []*PlatformDiff
+	// Change in total compressed layer size across all platforms, in bytes
+	SizeDifference int
+}
+
+// PlatformDiff describes what changed for a single platform
+type PlatformDiff struct {
+	// Platform (e.g., "linux/amd64"), empty for single-platform images
+	Platform string
+	// Layers only present in the new image
+	AddedLayers []*Layer
+	// Layers only present in the old image
+	RemovedLayers []*Layer
+	// Change in compressed layer size, in bytes
+	SizeDifference int
+	// Changes to env, labels, entrypoint and other config fields
+	ConfigChanges []*ConfigChange
+}
+
+// ConfigChange is a single changed image config value
+type ConfigChange struct {
+	// Changed field (e.g., "env", "label", "entrypoint")
+	Field string
+	// Env variable or label name, empty for other fields
+	Key string
+	// Old value, empty if added
+	Before string
+	// New value, empty if removed
+	After string
+}
+
+// Diff compares two images
+//
+// Reports changed platforms, added and removed layers, config changes and
+// the size difference.
+//
+// Example: crane manifest a && crane manifest b && crane config a && crane config b
+func (m *Crane) Diff(
+	ctx context.Context,
+	// Old image reference (e.g., "ghcr.io/org/app@sha256:...")
+	a string,
+	// New image reference (e.g., "ghcr.io/org/app:latest")
+	b string,
+) (*ImageDiff, error) {
+	manifestA, err := m.Manifest(ctx, a)
+	if err != nil {
+		return nil, err
+	}
+	manifestB, err := m.Manifest(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &ImageDiff{From: a, To: b}
+
+	platformsA := platformMap(manifestA)
+	platformsB := platformMap(manifestB)
+
+	switch {
+	case !manifestA.Index && !manifestB.Index:
+		// Two single-platform images are compared directly, whatever their platform
+		platformsA = map[string]*PlatformManifest{"": manifestA.Manifests[0]}
+		platformsB = map[string]*PlatformManifest{"": manifestB.Manifests[0]}
+	case !manifestA.Index:
+		if platformsA, err = m.singlePlatform(ctx, a, manifestA); err != nil {
+			return nil, err
+		}
+	case !manifestB.Index:
+		if platformsB, err = m.singlePlatform(ctx, b, manifestB); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, platform := range sortedKeys(platformsA) {
+		if _, ok := platformsB[platform]; !ok {
+			diff.RemovedPlatforms = append(diff.RemovedPlatforms, platform)
+			diff.SizeDifference -= platformsA[platform].Size
+		}
+	}
+
+	for _, platform := range sortedKeys(platformsB) {
+		pb := platformsB[platform]
+		pa, ok := platformsA[platform]
+		if !ok {
+			diff.AddedPlatforms = append(diff.AddedPlatforms, platform)
+			diff.SizeDifference += pb.Size
+			continue
+		}
+
+		pd := &PlatformDiff{
+			Platform:       platform,
+			AddedLayers:    layerDifference(pb.Layers, pa.Layers),
+			RemovedLayers:  layerDifference(pa.Layers, pb.Layers),
+			SizeDifference: pb.Size - pa.Size,
+		}
+		diff.SizeDifference += pd.SizeDifference
+
+		if pa.ConfigDigest != pb.ConfigDigest {
+			configA, err := m.Config(ctx, a, indexPlatform(manifestA, platform))
+			if err != nil {
+				return nil, err
+			}
+			configB, err := m.Config(ctx, b, indexPlatform(manifestB, platform))
+			if err != nil {
+				return nil, err
+			}
+			pd.ConfigChanges = configChanges(configA, configB)
+		}
+
+		diff.Platforms = append(diff.Platforms, pd)
+	}
+
+	return diff, nil
+}
+
+// Markdown renders the diff as Markdown, e.g. for release notes
+func (d *ImageDiff) Markdown() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "## Image changes\n\n`%s` → `%s`\n\n", d.From, d.To)
+	fmt.Fprintf(&b, "Size difference: %s\n", formatSize(d.SizeDifference))
+
+	for _, platform := range d.AddedPlatforms {
+		fmt.Fprintf(&b, "\n- Added platform `%s`", platform)
+	}
+	for _, platform := range d.RemovedPlatforms {
+		fmt.Fprintf(&b, "\n- Removed platform `%s`", platform)
+	}
+	if len(d.AddedPlatforms)+len(d.RemovedPlatforms) > 0 {
+		b.WriteString("\n")
+	}
+
+	for _, pd := range d.Platforms {
+		name := pd.Platform
+		if name == "" {
+			name = "image"
+		}
+		fmt.Fprintf(&b, "\n### %s\n\n", name)
+
+		if len(pd.AddedLayers)+len(pd.RemovedLayers)+len(pd.ConfigChanges) == 0 {
+			b.WriteString("No changes.\n")
+			continue
+		}
+
+		fmt.Fprintf(&b, "Size difference: %s\n\n", formatSize(pd.SizeDifference))
+		for _, l := range pd.AddedLayers {
+			fmt.Fprintf(&b, "- Added layer `%s` (%s)\n", l.Digest, formatSize(l.Size))
+		}
+		for _, l := range pd.RemovedLayers {
+			fmt.Fprintf(&b, "- Removed layer `%s` (%s)\n", l.Digest, formatSize(-l.Size))
+		}
+		for _, c := range pd.ConfigChanges {
+			field := c.Field
+			if c.Key != "" {
+				field += " " + c.Key
+			}
+			fmt.Fprintf(&b, "- Changed %s: `%s` → `%s`\n", field, c.Before, c.After)
+		}
+	}
+
+	return b.String()
+}
+
+// platformMap indexes the manifests of an image by platform
+func platformMap(manifest *ImageManifest) map[string]*PlatformManifest {
+	platforms := map[string]*PlatformManifest{}
+	for _, pm := range manifest.Manifests {
+		// Skip attestation manifests, which all share the unknown platform
+		if pm.Platform == "unknown/unknown" {
+			continue
+		}
+		platforms[pm.Platform] = pm
+	}
+	return platforms
+}
+
+// singlePlatform indexes a single-platform image by the platform in its config
+//
+// This matches it against the same platform of an image index.
+func (m *Crane) singlePlatform(ctx context.Context, image string, manifest *ImageManifest) (map[string]*PlatformManifest, error) {
+	config, err := m.Config(ctx, image, "")
+	if err != nil {
+		return nil, err
+	}
+
+	pm := *manifest.Manifests[0]
+	pm.Platform = config.Platform()
+	return map[string]*PlatformManifest{pm.Platform: &pm}, nil
+}
+
+// indexPlatform returns the platform to select in an image, empty for a single-platform image
+func indexPlatform(manifest *ImageManifest, platform string) string {
+	if !manifest.Index {
+		return ""
+	}
+	return platform
+}
+
+// layerDifference returns the layers in a that are not in b
+func layerDifference(a, b []*Layer) []*Layer {
+	inB := map[string]bool{}
+	for _, l := range b {
+		inB[l.Digest] = true
+	}
+
+	var diff []*Layer
+	for _, l := range a {
+		if !inB[l.Digest] {
+			diff = append(diff, l)
+		}
+	}
+	return diff
+}
+
+// configChanges compares two image configs
+func configChanges(a, b *ImageConfig) []*ConfigChange {
+	var changes []*ConfigChange
+
+	single := func(field, before, after string) {
+		if before != after {
+			changes = append(changes, &ConfigChange{Field: field, Before: before, After: after})
+		}
+	}
+	single("entrypoint", strings.Join(a.Entrypoint, " "), strings.Join(b.Entrypoint, " "))
+	single("cmd", strings.Join(a.Cmd, " "), strings.Join(b.Cmd, " "))
+	single("user", a.User, b.User)
+	single("workingDir", a.WorkingDir, b.WorkingDir)
+
+	keyed := func(field string, before, after map[string]string) {
+		keys := map[string]bool{}
+		for k := range before {
+			keys[k] = true
+		}
+		for k := range after {
+			keys[k] = true
+		}
+		for _, k := range sortedKeys(keys) {
+			if before[k] != after[k] {
+				changes = append(changes, &ConfigChange{Field: field, Key: k, Before: before[k], After: after[k]})
+			}
+		}
+	}
+	keyed("env", envMap(a.Env), envMap(b.Env))
+	keyed("label", labelMap(a.Labels), labelMap(b.Labels))
+
+	return changes
+}
+
+// envMap converts KEY=value entries to a map
+func envMap(env []string) map[string]string {
+	vars := map[string]string{}
+	for _, e := range env {
+		k, v, _ := strings.Cut(e, "=")
+		vars[k] = v
+	}
+	return vars
+}
+
+// labelMap converts labels to a map
+func labelMap(labels []*ImageLabel) map[string]string {
+	values := map[string]string{}
+	for _, l := range labels {
+		values[l.Key] = l.Value
+	}
+	return values
+}
+
+// sortedKeys returns the keys of a map in sorted order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// formatSize formats a signed byte count for humans
+func formatSize(bytes int) string {
+	prefix := "+"
+	if bytes < 0 {
+		prefix = "-"
+		bytes = -bytes
+	}
+
+	switch {
+	case bytes >= 1<<20:
+		return fmt.Sprintf("%s%.1f MiB", prefix, float64(bytes)/(1<<20))
+	case bytes >= 1<<10:
+		return fmt.Sprintf("%s%.1f KiB", prefix, float64(bytes)/(1<<10))
+	}
+	return fmt.Sprintf("%s%d B", prefix, bytes)
+}
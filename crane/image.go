@@ -0,0 +1,268 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ImageManifest is a parsed image manifest or image index
+type ImageManifest struct {
+	// Digest of the manifest (e.g., "sha256:...")
+	Digest string
+	// Media type of the manifest
+	MediaType string
+	// Whether the manifest is an image index (multi-platform image)
+	Index bool
+	// One entry per platform; a single-platform image has exactly one
+	Manifests []*PlatformManifest
+	// Raw manifest JSON as returned by the registry
+	Raw string
+}
+
+// PlatformManifest is the manifest of a single platform
+type PlatformManifest struct {
+	// Platform (e.g., "linux/arm64"), empty for single-platform images
+	Platform string
+	// Digest of the platform manifest
+	Digest string
+	// Digest of the image config
+	ConfigDigest string
+	// Image layers in order
+	Layers []*Layer
+	// Total compressed size of all layers in bytes
+	Size int
+}
+
+// Layer is a single image layer
+type Layer struct {
+	// Layer digest
+	Digest string
+	// Layer media type
+	MediaType string
+	// Compressed layer size in bytes
+	Size int
+}
+
+// Platforms returns the platforms of the image
+func (m *ImageManifest) Platforms() []string {
+	var platforms []string
+	for _, manifest := range m.Manifests {
+		platforms = append(platforms, manifest.Platform)
+	}
+	return platforms
+}
+
+// ImageConfig is a parsed image config file
+type ImageConfig struct {
+	// Image architecture (e.g., "amd64")
+	Architecture string
+	// Architecture variant (e.g., "v8"), empty if not set
+	Variant string
+	// Image OS (e.g., "linux")
+	Os string
+	// Creation time in RFC 3339 format
+	Created string
+	// User the container runs as
+	User string
+	// Environment variables in KEY=value form
+	Env []string
+	// Entrypoint
+	Entrypoint []string
+	// Default arguments
+	Cmd []string
+	// Working directory
+	WorkingDir string
+	// Image labels
+	Labels []*ImageLabel
+	// Build history, oldest first
+	History []*HistoryEntry
+	// Raw config JSON as returned by the registry
+	Raw string
+}
+
+// Platform returns the platform of the image (e.g., "linux/arm64/v8")
+func (c *ImageConfig) Platform() string {
+	platform := c.Os + "/" + c.Architecture
+	if c.Variant != "" {
+		platform += "/" + c.Variant
+	}
+	return platform
+}
+
+// ImageLabel is a single image label
+type ImageLabel struct {
+	Key   string
+	Value string
+}
+
+// HistoryEntry is a single step in the image build history
+type HistoryEntry struct {
+	// Creation time in RFC 3339 format
+	Created string
+	// Command that created the layer
+	CreatedBy string
+	// Optional comment
+	Comment string
+	// Whether the step produced no filesystem layer
+	EmptyLayer bool
+}
+
+// Label returns the value of a label, or an empty string if it is not set
+func (c *ImageConfig) Label(
+	// Label key (e.g., "org.opencontainers.image.source")
+	key string,
+) string {
+	for _, label := range c.Labels {
+		if label.Key == key {
+			return label.Value
+		}
+	}
+	return ""
+}
+
+// manifestJSON is the subset of OCI/Docker manifests and indexes we care about
+type manifestJSON struct {
+	MediaType string `json:"mediaType"`
+	Config    struct {
+		Digest string `json:"digest"`
+	} `json:"config"`
+	Layers []struct {
+		MediaType string `json:"mediaType"`
+		Digest    string `json:"digest"`
+		Size      int    `json:"size"`
+	} `json:"layers"`
+	Manifests []struct {
+		Digest   string `json:"digest"`
+		Platform *struct {
+			Os           string `json:"os"`
+			Architecture string `json:"architecture"`
+			Variant      string `json:"variant"`
+		} `json:"platform"`
+	} `json:"manifests"`
+}
+
+// configJSON is the subset of the OCI image config we care about
+type configJSON struct {
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant"`
+	Os           string `json:"os"`
+	Created      string `json:"created"`
+	Config       struct {
+		User       string            `json:"User"`
+		Env        []string          `json:"Env"`
+		Entrypoint []string          `json:"Entrypoint"`
+		Cmd        []string          `json:"Cmd"`
+		WorkingDir string            `json:"WorkingDir"`
+		Labels     map[string]string `json:"Labels"`
+	} `json:"config"`
+	History []struct {
+		Created    string `json:"created"`
+		CreatedBy  string `json:"created_by"`
+		Comment    string `json:"comment"`
+		EmptyLayer bool   `json:"empty_layer"`
+	} `json:"history"`
+}
+
+// parseManifest parses a manifest or index
+//
+// For an index, only the platform list is filled in; the caller fetches
+// the per-platform manifests and fills in their layers.
+func parseManifest(raw string) (*ImageManifest, *manifestJSON, error) {
+	var m manifestJSON
+	if err := json.Unmarshal([]byte(raw), &m); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+
+	manifest := &ImageManifest{
+		Digest:    digestOf(raw),
+		MediaType: m.MediaType,
+		Index:     len(m.Manifests) > 0,
+		Raw:       raw,
+	}
+
+	if !manifest.Index {
+		manifest.Manifests = []*PlatformManifest{platformManifest("", manifest.Digest, &m)}
+	}
+
+	return manifest, &m, nil
+}
+
+// platformManifest builds a PlatformManifest from a single-platform manifest
+func platformManifest(platform, digest string, m *manifestJSON) *PlatformManifest {
+	pm := &PlatformManifest{
+		Platform:     platform,
+		Digest:       digest,
+		ConfigDigest: m.Config.Digest,
+	}
+	for _, l := range m.Layers {
+		pm.Layers = append(pm.Layers, &Layer{
+			Digest:    l.Digest,
+			MediaType: l.MediaType,
+			Size:      l.Size,
+		})
+		pm.Size += l.Size
+	}
+	return pm
+}
+
+// parseConfig parses an image config file
+func parseConfig(raw string) (*ImageConfig, error) {
+	var c configJSON
+	if err := json.Unmarshal([]byte(raw), &c); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+
+	config := &ImageConfig{
+		Architecture: c.Architecture,
+		Variant:      c.Variant,
+		Os:           c.Os,
+		Created:      c.Created,
+		User:         c.Config.User,
+		Env:          c.Config.Env,
+		Entrypoint:   c.Config.Entrypoint,
+		Cmd:          c.Config.Cmd,
+		WorkingDir:   c.Config.WorkingDir,
+		Raw:          raw,
+	}
+
+	for key, value := range c.Config.Labels {
+		config.Labels = append(config.Labels, &ImageLabel{Key: key, Value: value})
+	}
+	sort.Slice(config.Labels, func(i, j int) bool {
+		return config.Labels[i].Key < config.Labels[j].Key
+	})
+
+	for _, h := range c.History {
+		config.History = append(config.History, &HistoryEntry{
+			Created:    h.Created,
+			CreatedBy:  h.CreatedBy,
+			Comment:    h.Comment,
+			EmptyLayer: h.EmptyLayer,
+		})
+	}
+
+	return config, nil
+}
+
+// digestOf returns the sha256 digest of a raw manifest
+func digestOf(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// repository strips the tag or digest from an image reference
+//
+// Example: ghcr.io/org/app:1.0@sha256:abc -> ghcr.io/org/app
+func repository(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	return image
+}
@@ -3,13 +3,19 @@
 // Crane is a tool from google/go-containerregistry for interacting with
 // remote images and registries. This module provides a Dagger interface
 // to common Crane operations like listing tags, getting digests, copying
-// images, and inspecting manifests.
+// images, and inspecting manifests. Manifest and Config return typed
+// objects, so labels and layers can be asserted on without jq.
+// Append, Mutate and Rebase modify remote images without a daemon.
+//
+// Use WithRegistryAuth to authenticate against one or more private registries.
 
 package main
 
 import (
 	"context"
 	"fmt"
+	"strings"
+	"time"
 
 	"dagger/crane/internal/dagger"
 )
@@ -18,21 +24,116 @@ func New(
 	// renovate: datasource=docker depName=gcr.io/go-containerregistry/crane
 	// +default="v0.20.3@sha256:fc86bcad43a000c2a1ca926a1e167db26c053cebc3fa5d14285c72773fb8c11d"
 	imageTag string,
+	// +optional
+	// Allow plain HTTP registries (e.g., a local registry:2 service)
+	insecure bool,
 ) *Crane {
 	return &Crane{
 		ImageTag: imageTag,
+		Insecure: insecure,
 	}
 }
 
 type Crane struct {
-	ImageTag string
+	ImageTag      string
+	Insecure      bool
+	RegistryAuths []*RegistryAuth
+	Services      []*ServiceBinding
+}
+
+// ServiceBinding is a service reachable from the Crane container
+type ServiceBinding struct {
+	// Hostname the service is reachable at
+	Alias string
+	// Service to bind (e.g., a registry:2 container)
+	Service *dagger.Service
+}
+
+// RegistryAuth holds credentials for a single registry
+type RegistryAuth struct {
+	// Registry host (e.g., "ghcr.io")
+	Registry string
+	// Registry username
+	Username string
+	// Registry password or token
+	Password *dagger.Secret
+}
+
+// WithRegistryAuth adds credentials for a registry
+//
+// Can be called several times to authenticate against multiple registries.
+func (m *Crane) WithRegistryAuth(
+	// Registry host (e.g., "ghcr.io")
+	registry string,
+	// Registry username
+	username string,
+	// Registry password or token
+	password *dagger.Secret,
+) *Crane {
+	m.RegistryAuths = append(m.RegistryAuths, &RegistryAuth{
+		Registry: registry,
+		Username: username,
+		Password: password,
+	})
+	return m
+}
+
+// WithServiceBinding makes a service (e.g., a local registry) reachable under the given hostname
+func (m *Crane) WithServiceBinding(
+	// Hostname the service is reachable at (e.g., "registry")
+	alias string,
+	// Service to bind
+	service *dagger.Service,
+) *Crane {
+	m.Services = append(m.Services, &ServiceBinding{
+		Alias:   alias,
+		Service: service,
+	})
+	return m
 }
 
 // Base returns a configured Crane container
 func (m *Crane) Base() *dagger.Container {
-	return dag.Container().
+	ctr := dag.Container().
 		From(fmt.Sprintf("gcr.io/go-containerregistry/crane:%s", m.ImageTag)).
 		WithoutEntrypoint()
+
+	for _, binding := range m.Services {
+		ctr = ctr.WithServiceBinding(binding.Alias, binding.Service)
+	}
+
+	return ctr
+}
+
+// authenticated returns the base container with a docker config for all registry credentials
+//
+// A raw docker config secret, if given, takes precedence over WithRegistryAuth.
+func (m *Crane) authenticated(secret *dagger.Secret) *dagger.Container {
+	config := dag.DockerConfig()
+	for _, a := range m.RegistryAuths {
+		config = config.WithRegistryAuth(a.Registry, a.Username, a.Password)
+	}
+
+	// Registry state changes outside of Dagger, so never reuse cached results
+	ctr := m.Base().
+		WithEnvVariable("CACHEBUSTER", time.Now().String())
+
+	return config.Mount(ctr, dagger.DockerConfigMountOpts{Config: secret})
+}
+
+// run executes a crane command in an authenticated container and returns stdout
+func (m *Crane) run(ctx context.Context, secret *dagger.Secret, args []string) (string, error) {
+	return m.authenticated(secret).
+		WithExec(m.command(args)).
+		Stdout(ctx)
+}
+
+// command adds global flags to a crane command
+func (m *Crane) command(args []string) []string {
+	if m.Insecure {
+		return append([]string{args[0], "--insecure"}, args[1:]...)
+	}
+	return args
 }
 
 // List returns all tags for a given image repository
@@ -43,9 +144,7 @@ func (m *Crane) List(
 	// Repository to list tags from (e.g., "gcr.io/go-containerregistry/crane")
 	repository string,
 ) (string, error) {
-	return m.Base().
-		WithExec([]string{"crane", "ls", repository}).
-		Stdout(ctx)
+	return m.run(ctx, nil, []string{"crane", "ls", repository})
 }
 
 // Digest returns the digest of a specific image
@@ -56,35 +155,76 @@ func (m *Crane) Digest(
 	// Full image reference (e.g., "gcr.io/go-containerregistry/crane:latest")
 	image string,
 ) (string, error) {
-	return m.Base().
-		WithExec([]string{"crane", "digest", image}).
-		Stdout(ctx)
+	return m.run(ctx, nil, []string{"crane", "digest", image})
 }
 
-// Manifest returns the manifest of a specific image
+// Manifest returns the parsed manifest of a specific image
+//
+// For a multi-platform image, the manifest of every platform is fetched as well.
 //
 // Example: crane manifest gcr.io/go-containerregistry/crane:latest
 func (m *Crane) Manifest(
 	ctx context.Context,
 	// Full image reference (e.g., "gcr.io/go-containerregistry/crane:latest")
 	image string,
-) (string, error) {
-	return m.Base().
-		WithExec([]string{"crane", "manifest", image}).
-		Stdout(ctx)
+) (*ImageManifest, error) {
+	raw, err := m.run(ctx, nil, []string{"crane", "manifest", image})
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, index, err := parseManifest(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range index.Manifests {
+		platform := "unknown/unknown"
+		if p := entry.Platform; p != nil {
+			platform = p.Os + "/" + p.Architecture
+			if p.Variant != "" {
+				platform += "/" + p.Variant
+			}
+		}
+
+		childRaw, err := m.run(ctx, nil, []string{"crane", "manifest", repository(image) + "@" + entry.Digest})
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch %s manifest: %w", platform, err)
+		}
+
+		child, childJSON, err := parseManifest(childRaw)
+		if err != nil {
+			return nil, err
+		}
+
+		manifest.Manifests = append(manifest.Manifests, platformManifest(platform, child.Digest, childJSON))
+	}
+
+	return manifest, nil
 }
 
-// Config returns the config file of a specific image
+// Config returns the parsed config file of a specific image
 //
 // Example: crane config gcr.io/go-containerregistry/crane:latest
 func (m *Crane) Config(
 	ctx context.Context,
 	// Full image reference (e.g., "gcr.io/go-containerregistry/crane:latest")
 	image string,
-) (string, error) {
-	return m.Base().
-		WithExec([]string{"crane", "config", image}).
-		Stdout(ctx)
+	// +optional
+	// Platform to read the config for in a multi-platform image (e.g., "linux/arm64")
+	platform string,
+) (*ImageConfig, error) {
+	args := []string{"crane", "config", image}
+	if platform != "" {
+		args = append(args, "--platform", platform)
+	}
+
+	raw, err := m.run(ctx, nil, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseConfig(raw)
 }
 
 // Validate validates that an image reference exists
@@ -95,9 +235,7 @@ func (m *Crane) Validate(
 	// Full image reference (e.g., "gcr.io/go-containerregistry/crane:latest")
 	image string,
 ) (string, error) {
-	return m.Base().
-		WithExec([]string{"crane", "validate", "--remote", image}).
-		Stdout(ctx)
+	return m.run(ctx, nil, []string{"crane", "validate", "--remote", image})
 }
 
 // Copy copies an image from one repository to another
@@ -110,31 +248,81 @@ func (m *Crane) Copy(
 	// Destination image reference (e.g., "gcr.io/dest/image:tag")
 	destination string,
 	// +optional
-	// Registry secret for authentication
+	// Registry secret for authentication (docker config.json), overrides WithRegistryAuth
 	secret *dagger.Secret,
 ) (string, error) {
-	ctr := m.Base()
-
-	if secret != nil {
-		ctr = ctr.WithMountedSecret("/root/.docker/config.json", secret)
-	}
+	return m.run(ctx, secret, []string{"crane", "copy", source, destination})
+}
 
-	return ctr.
-		WithExec([]string{"crane", "copy", source, destination}).
-		Stdout(ctx)
+// ImageExport is the flattened filesystem of an image
+type ImageExport struct {
+	// Flattened filesystem as a tarball
+	Tarball *dagger.File
+	// Flattened filesystem as a directory
+	Rootfs *dagger.Directory
 }
 
-// Export exports image contents as a tarball
+// Export exports the flattened filesystem of a remote image
 //
-// Example: crane export image:tag output.tar
+// Example: crane export --platform linux/arm64 image:tag output.tar
 func (m *Crane) Export(
-	ctx context.Context,
 	// Full image reference (e.g., "gcr.io/go-containerregistry/crane:latest")
 	image string,
-) *dagger.File {
-	return m.Base().
-		WithExec([]string{"crane", "export", image, "/tmp/image.tar"}).
+	// +optional
+	// Platform to export from a multi-platform image (e.g., "linux/arm64")
+	platform string,
+) *ImageExport {
+	ctr := m.authenticated(nil)
+
+	args := []string{"crane", "export", image, "/tmp/image.tar"}
+	if platform != "" {
+		args = append(args, "--platform", platform)
+	}
+
+	tarball := ctr.
+		WithExec(m.command(args)).
+		File("/tmp/image.tar")
+
+	// The crane image has no tar, so extract the filesystem in Alpine
+	rootfs := dag.Alpine().Base().
+		WithMountedFile("/tmp/image.tar", tarball).
+		WithExec([]string{"mkdir", "-p", "/rootfs"}).
+		WithExec([]string{"tar", "-xf", "/tmp/image.tar", "-C", "/rootfs"}).
+		Directory("/rootfs")
+
+	return &ImageExport{
+		Tarball: tarball,
+		Rootfs:  rootfs,
+	}
+}
+
+// ExportContainer exports the flattened filesystem of a Dagger Container
+//
+// Use this to inspect an image before it is published.
+func (m *Crane) ExportContainer(
+	ctr *dagger.Container,
+) *ImageExport {
+	rootfs := ctr.Rootfs()
+
+	tarball := dag.Alpine().Base().
+		WithMountedDirectory("/rootfs", rootfs).
+		WithExec([]string{"tar", "-cf", "/tmp/image.tar", "-C", "/rootfs", "."}).
 		File("/tmp/image.tar")
+
+	return &ImageExport{
+		Tarball: tarball,
+		Rootfs:  rootfs,
+	}
+}
+
+// ExportTarball exports the flattened filesystem of a local image tarball
+//
+// Accepts OCI and docker save tarballs (e.g., from docker save or Container.AsTarball).
+func (m *Crane) ExportTarball(
+	// Image tarball
+	tarball *dagger.File,
+) *ImageExport {
+	return m.ExportContainer(dag.Container().Import(tarball))
 }
 
 // Tag adds a tag to an existing image
@@ -147,16 +335,59 @@ func (m *Crane) Tag(
 	// New tag to add
 	tag string,
 	// +optional
-	// Registry secret for authentication
+	// Registry secret for authentication (docker config.json), overrides WithRegistryAuth
 	secret *dagger.Secret,
 ) (string, error) {
-	ctr := m.Base()
+	return m.run(ctx, secret, []string{"crane", "tag", image, tag})
+}
+
+// Promote copies an image to another registry, tags it and verifies the digest
+//
+// All platforms of a multi-platform image are copied. Returns the
+// digest-pinned destination reference.
+//
+// Example: crane copy src dst && crane tag dst tag... && crane digest dst
+func (m *Crane) Promote(
+	ctx context.Context,
+	// Source image reference (e.g., "ghcr.io/org/app:1.2.3")
+	source string,
+	// Destination image reference (e.g., "europe-docker.pkg.dev/project/repo/app:1.2.3")
+	destination string,
+	// +optional
+	// Additional tags to apply to the destination image (e.g., ["1.2", "latest"])
+	tags []string,
+) (string, error) {
+	digest, err := m.Digest(ctx, source)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve source digest: %w", err)
+	}
+	digest = strings.TrimSpace(digest)
 
-	if secret != nil {
-		ctr = ctr.WithMountedSecret("/root/.docker/config.json", secret)
+	// Copy by digest so the source can't move between resolving and copying
+	if _, err := m.Copy(ctx, repository(source)+"@"+digest, destination, nil); err != nil {
+		return "", fmt.Errorf("failed to copy %s: %w", source, err)
 	}
 
-	return ctr.
-		WithExec([]string{"crane", "tag", image, tag}).
-		Stdout(ctx)
+	for _, tag := range tags {
+		if _, err := m.Tag(ctx, destination, tag, nil); err != nil {
+			return "", fmt.Errorf("failed to tag %s: %w", tag, err)
+		}
+	}
+
+	// Verify the destination and every tag resolve to the source digest
+	refs := []string{destination}
+	for _, tag := range tags {
+		refs = append(refs, repository(destination)+":"+tag)
+	}
+	for _, ref := range refs {
+		got, err := m.Digest(ctx, ref)
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve %s: %w", ref, err)
+		}
+		if got = strings.TrimSpace(got); got != digest {
+			return "", fmt.Errorf("digest mismatch for %s: expected %s, got %s", ref, digest, got)
+		}
+	}
+
+	return repository(destination) + "@" + digest, nil
 }
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"dagger/crane/internal/dagger"
+)
+
+// Append adds a layer built from a directory on top of a remote image and pushes the result
+//
+// No container daemon is needed; the base image layers are not downloaded.
+// On a multi-platform base image, the layer is added to every platform.
+//
+// Example: crane mutate base:tag --append layer.tar --tag dest:tag
+func (m *Crane) Append(
+	ctx context.Context,
+	// Base image reference (e.g., "ghcr.io/org/app:1.0")
+	base string,
+	// Directory whose contents become the new layer, relative to the image root
+	layer *dagger.Directory,
+	// Destination image reference (e.g., "ghcr.io/org/app:1.0-patched")
+	destination string,
+) (string, error) {
+	// The crane image has no tar, so build the layer tarball in Alpine
+	tarball := dag.Alpine().Base().
+		WithDirectory("/layer", layer).
+		WithExec([]string{"tar", "-cf", "/layer.tar", "-C", "/layer", "."}).
+		File("/layer.tar")
+
+	ctr := m.authenticated(nil).
+		WithMountedFile("/tmp/layer.tar", tarball)
+
+	return m.apply(ctx, ctr, base, destination, func(ref, platform string) []string {
+		args := []string{"crane", "mutate", ref, "--append", "/tmp/layer.tar"}
+		if platform != "" {
+			args = append(args, "--repo", repository(destination))
+		}
+		return args
+	})
+}
+
+// Mutate changes the config of a remote image and pushes the result
+//
+// On a multi-platform image, the config of every platform is changed.
+//
+// Example: crane mutate image:tag --label key=value --env KEY=value --entrypoint /bin/app --tag dest:tag
+func (m *Crane) Mutate(
+	ctx context.Context,
+	// Image reference to mutate (e.g., "ghcr.io/org/app:1.0")
+	image string,
+	// +optional
+	// Labels to set in key=value form
+	labels []string,
+	// +optional
+	// Environment variables to set in KEY=value form
+	env []string,
+	// +optional
+	// New entrypoint
+	entrypoint []string,
+	// +optional
+	// Destination image reference; defaults to overwriting the source tag
+	destination string,
+) (string, error) {
+	var flags []string
+	for _, label := range labels {
+		flags = append(flags, "--label", label)
+	}
+	for _, e := range env {
+		flags = append(flags, "--env", e)
+	}
+	if len(entrypoint) > 0 {
+		flags = append(flags, "--entrypoint", strings.Join(entrypoint, ","))
+	}
+
+	return m.apply(ctx, m.authenticated(nil), image, destination, func(ref, platform string) []string {
+		args := append([]string{"crane", "mutate", ref}, flags...)
+		if platform != "" && destination != "" {
+			args = append(args, "--repo", repository(destination))
+		}
+		return args
+	})
+}
+
+// Rebase moves an image onto a new base image and pushes the result
+//
+// Use this to pick up a patched base image (e.g., a newer nginx digest)
+// without rebuilding the layers on top of it. On a multi-platform image,
+// every platform is rebased onto the same platform of the new base.
+//
+// Example: crane rebase image:tag --old_base nginx@sha256:old --new_base nginx@sha256:new --tag dest:tag
+func (m *Crane) Rebase(
+	ctx context.Context,
+	// Image reference to rebase (e.g., "ghcr.io/staticaland/athame/mkdocs-demo:latest")
+	image string,
+	// New base image reference, ideally pinned by digest
+	newBase string,
+	// +optional
+	// Current base image reference; read from the org.opencontainers.image.base.* annotations if omitted
+	oldBase string,
+	// +optional
+	// Destination image reference; defaults to overwriting the source tag
+	destination string,
+) (string, error) {
+	return m.apply(ctx, m.authenticated(nil), image, destination, func(ref, platform string) []string {
+		args := []string{"crane", "rebase", ref, "--new_base", newBase}
+		if oldBase != "" {
+			args = append(args, "--old_base", oldBase)
+		}
+		if platform != "" {
+			// Resolve the old and new base to this platform
+			args = append(args, "--platform", platform)
+			if destination != "" {
+				// A tag pinned by digest makes crane push by the rebased digest
+				_, digest, _ := strings.Cut(ref, "@")
+				args = append(args, "--tag", repository(destination)+"@"+digest)
+			}
+		}
+		return args
+	})
+}
+
+// apply runs a crane command that modifies an image and returns the pushed reference
+//
+// command builds the crane command for an image reference. On an image index,
+// it runs once per platform manifest, referenced by digest so crane pushes each
+// result by digest, and a new index of the results is pushed to the
+// destination. Attestation manifests are dropped, as they describe the
+// original platform manifests.
+func (m *Crane) apply(
+	ctx context.Context,
+	ctr *dagger.Container,
+	image string,
+	destination string,
+	command func(ref, platform string) []string,
+) (string, error) {
+	manifest, err := m.Manifest(ctx, image)
+	if err != nil {
+		return "", err
+	}
+
+	if !manifest.Index {
+		args := command(image, "")
+		if destination != "" {
+			args = append(args, "--tag", destination)
+		}
+		out, err := ctr.WithExec(m.command(args)).Stdout(ctx)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(out), nil
+	}
+
+	if destination == "" {
+		if strings.Contains(image, "@") {
+			return "", fmt.Errorf("%s is pinned by digest, so the new index needs a destination", image)
+		}
+		destination = image
+	}
+
+	index := []string{"crane", "index", "append", "--tag", destination}
+	for _, pm := range manifest.Manifests {
+		if pm.Platform == "unknown/unknown" {
+			continue
+		}
+
+		out, err := ctr.
+			WithExec(m.command(command(repository(image)+"@"+pm.Digest, pm.Platform))).
+			Stdout(ctx)
+		if err != nil {
+			return "", fmt.Errorf("failed to update %s: %w", pm.Platform, err)
+		}
+		index = append(index, "--manifest", strings.TrimSpace(out))
+	}
+
+	out, err := ctr.WithExec(m.command(index)).Stdout(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(out), nil
+}
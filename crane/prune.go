@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// PruneResult describes the tags removed (or, in a dry run, to be removed) by Prune
+type PruneResult struct {
+	// Whether this was a dry run and nothing was deleted
+	DryRun bool
+	// How the matching tags were ordered: "semver" or "created"
+	SortedBy string
+	// Matching tags that were kept, newest first
+	Kept []string
+	// Tags that were (or would be) deleted, newest first
+	Deleted []*PrunedTag
+	// Matching tags outside the retention policy that were kept because
+	// their digest is still referenced by another tag
+	Retained []*PrunedTag
+}
+
+// PrunedTag is a tag selected for deletion together with its digest
+type PrunedTag struct {
+	Tag    string
+	Digest string
+}
+
+// Prune deletes old tags from a repository according to a retention policy
+//
+// Tags matching the pattern are sorted newest first, by semantic version if
+// every matching tag is one, otherwise by the image creation time. All but the
+// newest keep tags are deleted. Tags not matching the pattern are never deleted,
+// and neither is a digest that is still referenced by a tag being kept.
+//
+// Example: crane ls repo && crane delete repo@digest...
+func (m *Crane) Prune(
+	ctx context.Context,
+	// Repository to prune (e.g., "ghcr.io/org/app")
+	repository string,
+	// Number of matching tags to keep
+	// +default=10
+	keep int,
+	// +optional
+	// Regular expression selecting the tags to consider (e.g., "^v?[0-9]+\\.[0-9]+\\.[0-9]+$")
+	// +default=".*"
+	pattern string,
+	// +optional
+	// Only report what would be deleted
+	dryRun bool,
+) (*PruneResult, error) {
+	if keep < 0 {
+		return nil, fmt.Errorf("keep must not be negative, got %d", keep)
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %w", err)
+	}
+
+	out, err := m.List(ctx, repository)
+	if err != nil {
+		return nil, err
+	}
+	tags := strings.Fields(out)
+
+	// Resolve the digest of every tag, matching or not, so shared digests are detected
+	digests := make([]string, len(tags))
+	eg, gctx := errgroup.WithContext(ctx)
+	eg.SetLimit(8)
+	for i, tag := range tags {
+		eg.Go(func() error {
+			digest, err := m.Digest(gctx, repository+":"+tag)
+			if err != nil {
+				return fmt.Errorf("failed to resolve %s: %w", tag, err)
+			}
+			digests[i] = strings.TrimSpace(digest)
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+
+	digestOf := map[string]string{}
+	var matching []string
+	for i, tag := range tags {
+		digestOf[tag] = digests[i]
+		if re.MatchString(tag) {
+			matching = append(matching, tag)
+		}
+	}
+
+	sortedBy, err := m.sortNewestFirst(ctx, repository, matching)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &PruneResult{
+		DryRun:   dryRun,
+		SortedBy: sortedBy,
+	}
+
+	if keep > len(matching) {
+		keep = len(matching)
+	}
+	result.Kept = matching[:keep]
+
+	// Digests that must survive: kept tags and tags outside the pattern
+	protected := map[string]bool{}
+	for _, tag := range tags {
+		if !re.MatchString(tag) {
+			protected[digestOf[tag]] = true
+		}
+	}
+	for _, tag := range result.Kept {
+		protected[digestOf[tag]] = true
+	}
+
+	deleted := map[string]bool{}
+	for _, tag := range matching[keep:] {
+		pruned := &PrunedTag{Tag: tag, Digest: digestOf[tag]}
+		if protected[pruned.Digest] {
+			result.Retained = append(result.Retained, pruned)
+			continue
+		}
+		result.Deleted = append(result.Deleted, pruned)
+
+		// Deleting a manifest removes every tag pointing at it, so delete each digest once
+		if dryRun || deleted[pruned.Digest] {
+			continue
+		}
+		if _, err := m.run(ctx, nil, []string{"crane", "delete", repository + "@" + pruned.Digest}); err != nil {
+			return nil, fmt.Errorf("failed to delete %s: %w", tag, err)
+		}
+		deleted[pruned.Digest] = true
+	}
+
+	return result, nil
+}
+
+// sortNewestFirst sorts tags newest first and returns the ordering used
+func (m *Crane) sortNewestFirst(ctx context.Context, repository string, tags []string) (string, error) {
+	versions := make(map[string]*semver, len(tags))
+	allSemver := true
+	for _, tag := range tags {
+		if versions[tag] = parseSemver(tag); versions[tag] == nil {
+			allSemver = false
+		}
+	}
+
+	if allSemver {
+		sort.SliceStable(tags, func(i, j int) bool {
+			return versions[tags[i]].compare(versions[tags[j]]) > 0
+		})
+		return "semver", nil
+	}
+
+	created := make(map[string]time.Time, len(tags))
+	for _, tag := range tags {
+		config, err := m.Config(ctx, repository+":"+tag, "")
+		if err != nil {
+			return "", fmt.Errorf("failed to read config of %s: %w", tag, err)
+		}
+		// Images without a creation time (e.g., reproducible builds) sort as oldest
+		created[tag], _ = time.Parse(time.RFC3339Nano, config.Created)
+	}
+
+	sort.SliceStable(tags, func(i, j int) bool {
+		return created[tags[i]].After(created[tags[j]])
+	})
+	return "created", nil
+}
+
+// semver is a parsed semantic version
+type semver struct {
+	major, minor, patch int
+	prerelease          []string
+}
+
+// parseSemver parses a semantic version with an optional "v" prefix, or returns nil
+func parseSemver(tag string) *semver {
+	version := strings.TrimPrefix(tag, "v")
+	version, _, _ = strings.Cut(version, "+")
+	version, prerelease, hasPrerelease := strings.Cut(version, "-")
+
+	parts := strings.Split(version, ".")
+	if len(parts) != 3 {
+		return nil
+	}
+
+	var numbers [3]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil
+		}
+		numbers[i] = n
+	}
+
+	v := &semver{major: numbers[0], minor: numbers[1], patch: numbers[2]}
+	if hasPrerelease {
+		v.prerelease = strings.Split(prerelease, ".")
+	}
+	return v
+}
+
+// compare returns -1, 0 or 1 following semver precedence rules
+func (v *semver) compare(other *semver) int {
+	for _, d := range []int{v.major - other.major, v.minor - other.minor, v.patch - other.patch} {
+		if d != 0 {
+			return sign(d)
+		}
+	}
+
+	// A release has higher precedence than any of its prereleases
+	switch {
+	case len(v.prerelease) == 0 && len(other.prerelease) == 0:
+		return 0
+	case len(v.prerelease) == 0:
+		return 1
+	case len(other.prerelease) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(v.prerelease) && i < len(other.prerelease); i++ {
+		a, b := v.prerelease[i], other.prerelease[i]
+		if a == b {
+			continue
+		}
+		an, aErr := strconv.Atoi(a)
+		bn, bErr := strconv.Atoi(b)
+		switch {
+		case aErr == nil && bErr == nil:
+			return sign(an - bn)
+		case aErr == nil:
+			return -1
+		case bErr == nil:
+			return 1
+		}
+		return strings.Compare(a, b)
+	}
+
+	return sign(len(v.prerelease) - len(other.prerelease))
+}
+
+// sign returns -1, 0 or 1 depending on the sign of n
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
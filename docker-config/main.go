@@ -0,0 +1,128 @@
+// A Dagger module for docker config.json registry credentials
+//
+// Builds the config.json that crane, oras, cosign and most other registry
+// clients read credentials from, and mounts it into their containers. The
+// config is owned by the container's user and found through DOCKER_CONFIG,
+// so it also works for images running as a nonroot user.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"dagger/docker-config/internal/dagger"
+)
+
+// configDir is where the config is mounted, independent of the user's home
+const configDir = "/docker-config"
+
+func New() *DockerConfig {
+	return &DockerConfig{}
+}
+
+type DockerConfig struct {
+	RegistryAuths []*RegistryAuth
+}
+
+// RegistryAuth holds credentials for a single registry
+type RegistryAuth struct {
+	// Registry host (e.g., "ghcr.io")
+	Registry string
+	// Registry username
+	Username string
+	// Registry password or token
+	Password *dagger.Secret
+}
+
+// WithRegistryAuth adds credentials for a registry
+//
+// Can be called several times to authenticate against multiple registries.
+func (m *DockerConfig) WithRegistryAuth(
+	// Registry host (e.g., "ghcr.io")
+	registry string,
+	// Registry username
+	username string,
+	// Registry password or token
+	password *dagger.Secret,
+) *DockerConfig {
+	m.RegistryAuths = append(m.RegistryAuths, &RegistryAuth{
+		Registry: registry,
+		Username: username,
+		Password: password,
+	})
+	return m
+}
+
+// Secret returns the docker config.json with all registry credentials
+func (m *DockerConfig) Secret(ctx context.Context) (*dagger.Secret, error) {
+	type authEntry struct {
+		Auth string `json:"auth"`
+	}
+	auths := map[string]authEntry{}
+
+	for _, a := range m.RegistryAuths {
+		password, err := a.Password.Plaintext(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read password for %s: %w", a.Registry, err)
+		}
+		auths[a.Registry] = authEntry{
+			Auth: base64.StdEncoding.EncodeToString([]byte(a.Username + ":" + password)),
+		}
+	}
+
+	config, err := json.Marshal(map[string]any{"auths": auths})
+	if err != nil {
+		return nil, err
+	}
+
+	// Name the secret after the registries and usernames, never after the
+	// credentials, as secret names show up in logs and traces
+	var accounts []string
+	for _, a := range m.RegistryAuths {
+		accounts = append(accounts, a.Registry+" "+a.Username)
+	}
+	sum := sha256.Sum256([]byte(strings.Join(accounts, "\n")))
+	return dag.SetSecret("docker-config-"+hex.EncodeToString(sum[:8]), string(config)), nil
+}
+
+// Mount mounts the docker config into a container and points DOCKER_CONFIG at it
+//
+// Returns the container unchanged if there are neither credentials nor a config to mount.
+func (m *DockerConfig) Mount(
+	ctx context.Context,
+	// Container running the registry client
+	ctr *dagger.Container,
+	// +optional
+	// Raw docker config.json to mount instead of the one built from WithRegistryAuth
+	config *dagger.Secret,
+) (*dagger.Container, error) {
+	if config == nil {
+		if len(m.RegistryAuths) == 0 {
+			return ctr, nil
+		}
+
+		var err error
+		config, err = m.Secret(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Secrets are mounted read-only for their owner, root unless given otherwise
+	user, err := ctr.User(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return ctr.
+		WithMountedSecret(configDir+"/config.json", config, dagger.ContainerWithMountedSecretOpts{
+			Owner: user,
+		}).
+		WithEnvVariable("DOCKER_CONFIG", configDir), nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"dagger/go-ci/internal/dagger"
+)
+
+// BuildInfo is the version metadata stamped into binaries and images
+type BuildInfo struct {
+	// Version (e.g., "v1.2.3" or "v1.2.3-4-gabcdef0-dirty"), "dev" without git metadata
+	Version string
+	// Full commit hash, empty without git metadata
+	Commit string
+	// Commit date in RFC 3339 format, empty without git metadata
+	Date string
+}
+
+// BuildInfo reads version metadata from the git repository of the source directory
+//
+// The version comes from the constructor when set, otherwise from git describe.
+//
+// Example: git describe --tags --always --dirty && git log -1 --format=%H%n%cI
+func (m *GoCi) BuildInfo(
+	ctx context.Context,
+	// source code location
+	// +defaultPath="/"
+	source *dagger.Directory,
+) (*BuildInfo, error) {
+	info := &BuildInfo{Version: "dev"}
+	if m.Version != "" {
+		info.Version = m.Version
+	}
+
+	hasGit, err := source.Exists(ctx, ".git")
+	if err != nil {
+		return nil, err
+	}
+	if !hasGit {
+		return info, nil
+	}
+
+	out, err := dag.Alpine().WithPackages([]string{"git"}).
+		WithMountedDirectory("/src", source).
+		WithWorkdir("/src").
+		WithExec([]string{"git", "config", "--global", "--add", "safe.directory", "/src"}).
+		WithExec([]string{"sh", "-c", "git describe --tags --always --dirty && git log -1 --format=%H%n%cI"}).
+		Stdout(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read git metadata: %w", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 3 {
+		return nil, fmt.Errorf("unexpected git output: %s", out)
+	}
+	if m.Version == "" {
+		info.Version = lines[0]
+	}
+	info.Commit = lines[1]
+	info.Date = lines[2]
+
+	return info, nil
+}
+
+// ldflags returns the linker flags injecting the build info into the version package
+func (m *GoCi) ldflags(info *BuildInfo) string {
+	flags := []string{"-X", fmt.Sprintf("%s.version=%s", m.VersionPackage, info.Version)}
+	if info.Commit != "" {
+		flags = append(flags, "-X", fmt.Sprintf("%s.commit=%s", m.VersionPackage, info.Commit))
+	}
+	if info.Date != "" {
+		flags = append(flags, "-X", fmt.Sprintf("%s.date=%s", m.VersionPackage, info.Date))
+	}
+	return strings.Join(flags, " ")
+}
+
+// withLabels stamps the build info as OCI image labels
+func withLabels(ctr *dagger.Container, title string, info *BuildInfo) *dagger.Container {
+	ctr = ctr.
+		WithLabel("org.opencontainers.image.title", title).
+		WithLabel("org.opencontainers.image.version", info.Version)
+	if info.Commit != "" {
+		ctr = ctr.WithLabel("org.opencontainers.image.revision", info.Commit)
+	}
+	if info.Date != "" {
+		ctr = ctr.WithLabel("org.opencontainers.image.created", info.Date)
+	}
+	return ctr
+}
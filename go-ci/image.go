@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"dagger/go-ci/internal/dagger"
+)
+
+// renovate: datasource=docker depName=gcr.io/distroless/static-debian12
+const distrolessImage = "gcr.io/distroless/static-debian12:nonroot"
+
+// RuntimeBase is the base image Go binaries are packaged on
+type RuntimeBase string
+
+const (
+	// Empty image with CA certificates, running as a non-root user
+	RuntimeBaseScratch RuntimeBase = "scratch"
+	// Distroless static image, running as a non-root user
+	RuntimeBaseDistroless RuntimeBase = "distroless"
+	// Pinned Alpine image from the alpine module
+	RuntimeBaseAlpine RuntimeBase = "alpine"
+)
+
+// RegistryAuth holds credentials for a single registry
+type RegistryAuth struct {
+	// Registry host (e.g., "ghcr.io")
+	Registry string
+	// Registry username
+	Username string
+	// Registry password or token
+	Password *dagger.Secret
+}
+
+// WithRegistryAuth adds credentials for a registry
+//
+// Can be called several times to authenticate against multiple registries.
+func (m *GoCi) WithRegistryAuth(
+	// Registry host (e.g., "ghcr.io")
+	registry string,
+	// Registry username
+	username string,
+	// Registry password or token
+	password *dagger.Secret,
+) *GoCi {
+	m.RegistryAuths = append(m.RegistryAuths, &RegistryAuth{
+		Registry: registry,
+		Username: username,
+		Password: password,
+	})
+	return m
+}
+
+// binary builds a static Go binary in a source container with the given Go environment and build info
+//
+// Version control information comes from the build info through -ldflags, as
+// the build image has no git, so -buildvcs is disabled explicitly.
+func (m *GoCi) binary(ctr *dagger.Container, name string, env map[string]string, info *BuildInfo) *dagger.File {
+	ctr = ctr.WithEnvVariable("CGO_ENABLED", "0")
+	for _, key := range sortedKeys(env) {
+		ctr = ctr.WithEnvVariable(key, env[key])
+	}
+
+	return ctr.
+		WithExec([]string{"go", "build", "-trimpath", "-buildvcs=false",
+			"-ldflags", m.ldflags(info), "-o", "/out/" + name}).
+		File("/out/" + name)
+}
+
+// runtime returns the runtime base container for a platform
+func (m *GoCi) runtime(platform dagger.Platform) (*dagger.Container, error) {
+	switch m.RuntimeBase {
+	case RuntimeBaseScratch:
+		return dag.Container(dagger.ContainerOpts{Platform: platform}).
+			WithFile("/etc/ssl/certs/ca-certificates.crt", m.Base().File("/etc/ssl/certs/ca-certificates.crt")).
+			WithUser("65532:65532"), nil
+	case RuntimeBaseDistroless:
+		return dag.Container(dagger.ContainerOpts{Platform: platform}).
+			From(distrolessImage), nil
+	case RuntimeBaseAlpine:
+		return dag.Alpine().Base(dagger.AlpineBaseOpts{Platform: platform}), nil
+	}
+	return nil, fmt.Errorf("unknown runtime base %q", m.RuntimeBase)
+}
+
+// image packages a binary on the runtime base for a platform, labelled with the build info
+func (m *GoCi) image(platform dagger.Platform, binary *dagger.File, binaryName string, info *BuildInfo) (*dagger.Container, error) {
+	ctr, err := m.runtime(platform)
+	if err != nil {
+		return nil, err
+	}
+
+	ctr = ctr.
+		WithFile(fmt.Sprintf("/bin/%s", binaryName), binary).
+		WithEntrypoint([]string{fmt.Sprintf("/bin/%s", binaryName)})
+	return withLabels(ctr, binaryName, info), nil
+}
+
+// Image builds a Go binary and packages it as a container image on the runtime base
+//
+// Version, commit and date are injected with -ldflags -X and stamped as OCI labels.
+func (m *GoCi) Image(
+	ctx context.Context,
+	// source code location
+	// +defaultPath="/"
+	source *dagger.Directory,
+	// binary name
+	// +default="app"
+	binaryName string,
+	// +optional
+	// Target platform, defaults to the engine platform
+	platform dagger.Platform,
+) (*dagger.Container, error) {
+	if platform == "" {
+		var err error
+		if platform, err = dag.DefaultPlatform(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	env, err := goEnv(platform)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := m.BuildInfo(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.image(platform, m.binary(m.withSource(source), binaryName, env, info), binaryName, info)
+}
+
+// Publish builds a Go binary and publishes it as a container image under every tag
+//
+// With several platforms, a multi-platform image is published.
+// Returns the digest-pinned address of each tag.
+func (m *GoCi) Publish(
+	ctx context.Context,
+	// source code location
+	// +defaultPath="/"
+	source *dagger.Directory,
+	// binary name
+	// +default="app"
+	binaryName string,
+	// image name, appended to the registry (e.g., "org/app")
+	// +default="myapp"
+	imageName string,
+	// Tags to publish
+	// +default=["latest"]
+	tags []string,
+	// +optional
+	// Target platforms (e.g., "linux/amd64", "linux/arm64"), defaults to the engine platform
+	platforms []dagger.Platform,
+) ([]string, error) {
+	var variants []*dagger.Container
+	if len(platforms) == 0 {
+		ctr, err := m.Image(ctx, source, binaryName, "")
+		if err != nil {
+			return nil, err
+		}
+		variants = append(variants, ctr)
+	} else {
+		build, err := m.BuildMatrix(ctx, source, platforms, binaryName)
+		if err != nil {
+			return nil, err
+		}
+		if len(build.PlatformVariants) == 0 {
+			return nil, fmt.Errorf("no linux platform to publish among %v", platforms)
+		}
+		variants = build.PlatformVariants
+	}
+
+	publisher := dag.Container()
+	for _, auth := range m.RegistryAuths {
+		publisher = publisher.WithRegistryAuth(auth.Registry, auth.Username, auth.Password)
+	}
+
+	var addrs []string
+	for _, tag := range tags {
+		addr, err := publisher.Publish(ctx, fmt.Sprintf("%s/%s:%s", m.Registry, imageName, tag), dagger.ContainerPublishOpts{
+			PlatformVariants: variants,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to publish %s: %w", tag, err)
+		}
+		addrs = append(addrs, addr)
+	}
+
+	return addrs, nil
+}
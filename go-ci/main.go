@@ -1,7 +1,7 @@
 // A Dagger module for Go CI/CD: linting, building, and publishing Go applications
 //
 // This module provides functions to lint Go code with golangci-lint,
-// build Go binaries, and publish container images to ttl.sh registry.
+// build Go binaries, and publish container images to a registry (ttl.sh by default).
 
 package main
 
@@ -18,14 +18,35 @@ func New(
 	// renovate: datasource=docker depName=golang
 	// +default="1.25.3-alpine3.22@sha256:aee43c3ccbf24fdffb7295693b6e33b21e01baec1b2a55acc351fde345e9ec34"
 	golangImageTag string,
+	// Registry images are published to (e.g., "ghcr.io")
+	// +default="ttl.sh"
+	registry string,
+	// Base image binaries are packaged on
+	// +default="alpine"
+	runtimeBase RuntimeBase,
+	// +optional
+	// Version to stamp into binaries and images, defaults to git describe of the source
+	version string,
+	// Package holding the version, commit and date variables set with -ldflags -X
+	// +default="main"
+	versionPackage string,
 ) *GoCi {
 	return &GoCi{
 		GolangImageTag: golangImageTag,
+		Registry:       registry,
+		RuntimeBase:    runtimeBase,
+		Version:        version,
+		VersionPackage: versionPackage,
 	}
 }
 
 type GoCi struct {
 	GolangImageTag string
+	Registry       string
+	RuntimeBase    RuntimeBase
+	Version        string
+	VersionPackage string
+	RegistryAuths  []*RegistryAuth
 }
 
 // Base returns the base container with Go installed
@@ -35,6 +56,17 @@ func (m *GoCi) Base() *dagger.Container {
 		WithoutEntrypoint()
 }
 
+// withSource returns the base container with the source mounted and the module and build caches enabled
+func (m *GoCi) withSource(source *dagger.Directory) *dagger.Container {
+	return m.Base().
+		WithMountedCache("/go/pkg/mod", dag.CacheVolume("go-mod")).
+		WithEnvVariable("GOMODCACHE", "/go/pkg/mod").
+		WithMountedCache("/root/.cache/go-build", dag.CacheVolume("go-build")).
+		WithEnvVariable("GOCACHE", "/root/.cache/go-build").
+		WithDirectory("/src", source).
+		WithWorkdir("/src")
+}
+
 // Lint runs golangci-lint on the provided Go source code
 func (m *GoCi) Lint(
 	ctx context.Context,
@@ -61,7 +93,7 @@ func (m *GoCi) Gosec(
 		Stdout(ctx)
 }
 
-// RunAllTests runs linter and gosec concurrently
+// RunAllTests runs linter, gosec, go test, govulncheck and the license check concurrently
 func (m *GoCi) RunAllTests(
 	ctx context.Context,
 	// +defaultPath="/"
@@ -82,12 +114,37 @@ func (m *GoCi) RunAllTests(
 		return err
 	})
 
+	// Run go test
+	eg.Go(func() error {
+		report, err := m.Test(gctx, source, []string{"./..."}, false, true)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Test results: %s\n", report.Summary())
+		return report.err()
+	})
+
+	// Run govulncheck
+	eg.Go(func() error {
+		report, err := m.Vulncheck(gctx, source)
+		if err != nil {
+			return err
+		}
+		return report.err()
+	})
+
+	// Check dependency licenses
+	eg.Go(func() error {
+		_, err := m.Licenses(gctx, source, defaultAllowedLicenses)
+		return err
+	})
+
 	// Wait for all tests to complete
 	// If any test fails, the error will be returned
 	return eg.Wait()
 }
 
-// Build builds a Go binary and publishes it as a container image to ttl.sh
+// Build builds a Go binary and publishes it as a container image tagged latest
 func (m *GoCi) Build(
 	ctx context.Context,
 	// source code location
@@ -96,33 +153,19 @@ func (m *GoCi) Build(
 	// binary name
 	// +default="app"
 	binaryName string,
-	// image name for ttl.sh
+	// image name, appended to the registry (e.g., "org/app")
 	// +default="myapp"
 	imageName string,
 ) (string, error) {
-	// Build the Go binary
-	builder := m.Base().
-		WithDirectory("/src", source).
-		WithWorkdir("/src").
-		WithEnvVariable("CGO_ENABLED", "0").
-		WithExec([]string{"go", "build", "-o", binaryName})
-
-	// Create production image on alpine base
-	prodImage := dag.Container().
-		From("alpine:latest").
-		WithFile(fmt.Sprintf("/bin/%s", binaryName), builder.File(fmt.Sprintf("/src/%s", binaryName))).
-		WithEntrypoint([]string{fmt.Sprintf("/bin/%s", binaryName)})
-
-	// Publish to ttl.sh registry
-	addr, err := prodImage.Publish(ctx, fmt.Sprintf("ttl.sh/%s:latest", imageName))
+	addrs, err := m.Publish(ctx, source, binaryName, imageName, []string{"latest"}, nil)
 	if err != nil {
 		return "", err
 	}
 
-	return addr, nil
+	return addrs[0], nil
 }
 
-// LintAndBuild runs all tests (linting and security scanning) concurrently, then builds and publishes if tests pass
+// LintAndBuild runs all tests (linting, security scanning, go test and supply-chain checks) concurrently, then builds and publishes if tests pass
 func (m *GoCi) LintAndBuild(
 	ctx context.Context,
 	// source code location
@@ -131,7 +174,7 @@ func (m *GoCi) LintAndBuild(
 	// binary name
 	// +default="app"
 	binaryName string,
-	// image name for ttl.sh
+	// image name, appended to the registry (e.g., "org/app")
 	// +default="myapp"
 	imageName string,
 ) (string, error) {
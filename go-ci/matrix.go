@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"dagger/go-ci/internal/dagger"
+)
+
+// MatrixBuild is the result of cross-compiling for several platforms
+type MatrixBuild struct {
+	// Binaries named by platform (e.g., app-linux-amd64, app-windows-amd64.exe)
+	Binaries *dagger.Directory
+	// One image per linux platform, to be published together as a multi-platform image
+	PlatformVariants []*dagger.Container
+}
+
+// Tarball returns the multi-platform image as an OCI tarball
+func (b *MatrixBuild) Tarball() *dagger.File {
+	return dag.Container().AsTarball(dagger.ContainerAsTarballOpts{
+		PlatformVariants: b.PlatformVariants,
+	})
+}
+
+// BuildMatrix cross-compiles a Go binary for several platforms
+//
+// Binaries are built on the host platform with GOOS/GOARCH, so no emulation is needed.
+// Each linux binary is also packaged into an image for its platform on the runtime base.
+//
+// Example: GOOS=linux GOARCH=arm64 go build -o app-linux-arm64
+func (m *GoCi) BuildMatrix(
+	ctx context.Context,
+	// source code location
+	// +defaultPath="/"
+	source *dagger.Directory,
+	// Target platforms (e.g., "linux/amd64", "linux/arm/v7", "darwin/arm64", "windows/amd64")
+	// +default=["linux/amd64", "linux/arm64"]
+	platforms []dagger.Platform,
+	// binary name
+	// +default="app"
+	binaryName string,
+) (*MatrixBuild, error) {
+	info, err := m.BuildInfo(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+
+	build := &MatrixBuild{
+		Binaries: dag.Directory(),
+	}
+
+	for _, platform := range platforms {
+		env, err := goEnv(platform)
+		if err != nil {
+			return nil, err
+		}
+
+		name := fmt.Sprintf("%s-%s", binaryName, strings.ReplaceAll(string(platform), "/", "-"))
+		if env["GOOS"] == "windows" {
+			name += ".exe"
+		}
+
+		binary := m.binary(m.withSource(source), name, env, info)
+		build.Binaries = build.Binaries.WithFile(name, binary)
+
+		if env["GOOS"] != "linux" {
+			continue
+		}
+
+		variant, err := m.image(platform, binary, binaryName, info)
+		if err != nil {
+			return nil, err
+		}
+		build.PlatformVariants = append(build.PlatformVariants, variant)
+	}
+
+	return build, nil
+}
+
+// goEnv returns the Go environment variables to cross-compile for a platform
+func goEnv(platform dagger.Platform) (map[string]string, error) {
+	parts := strings.Split(string(platform), "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid platform %q, expected os/arch[/variant]", platform)
+	}
+
+	env := map[string]string{
+		"GOOS":   parts[0],
+		"GOARCH": parts[1],
+	}
+	if len(parts) == 3 {
+		switch parts[1] {
+		case "arm":
+			// linux/arm/v7 -> GOARM=7
+			env["GOARM"] = strings.TrimPrefix(parts[2], "v")
+		case "amd64":
+			// linux/amd64/v3 -> GOAMD64=v3
+			env["GOAMD64"] = parts[2]
+		}
+	}
+	return env, nil
+}
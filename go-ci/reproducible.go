@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"dagger/go-ci/internal/dagger"
+)
+
+// sectionHashes prints the sha256 of a binary followed by the sha256 and size of each ELF section
+const sectionHashes = `package main
+
+import (
+	"crypto/sha256"
+	"debug/elf"
+	"fmt"
+	"os"
+)
+
+func main() {
+	contents, err := os.ReadFile(os.Args[1])
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("%x\n", sha256.Sum256(contents))
+
+	f, err := elf.Open(os.Args[1])
+	if err != nil {
+		panic(err)
+	}
+	for _, s := range f.Sections {
+		if s.Type == elf.SHT_NOBITS || s.Name == "" {
+			continue
+		}
+		data, err := s.Data()
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("%s %x %d\n", s.Name, sha256.Sum256(data), len(data))
+	}
+}
+`
+
+// sectionHash is the hash and size of a single section
+type sectionHash struct {
+	sha256 string
+	size   int
+}
+
+// VerifyReproducible builds the binary twice and checks that both builds are identical
+//
+// Both builds run the same go build as Publish for the engine platform, in
+// separate containers with separate source paths and empty build caches, and
+// with a fixed SOURCE_DATE_EPOCH (the commit date, when git metadata is
+// available). Fails with the differing ELF sections if the binaries are not
+// bit for bit identical.
+//
+// Example: go build -trimpath -buildvcs=false (twice, then compare the sha256)
+func (m *GoCi) VerifyReproducible(
+	ctx context.Context,
+	// source code location
+	// +defaultPath="/"
+	source *dagger.Directory,
+) (string, error) {
+	info, err := m.BuildInfo(ctx, source)
+	if err != nil {
+		return "", err
+	}
+
+	sourceDateEpoch := "0"
+	if info.Date != "" {
+		date, err := time.Parse(time.RFC3339, info.Date)
+		if err != nil {
+			return "", fmt.Errorf("invalid commit date %q: %w", info.Date, err)
+		}
+		sourceDateEpoch = strconv.FormatInt(date.Unix(), 10)
+	}
+
+	platform, err := dag.DefaultPlatform(ctx)
+	if err != nil {
+		return "", err
+	}
+	env, err := goEnv(platform)
+	if err != nil {
+		return "", err
+	}
+
+	build := func(attempt int) *dagger.File {
+		workdir := fmt.Sprintf("/build-%d/src", attempt)
+		ctr := m.Base().
+			WithMountedCache("/go/pkg/mod", dag.CacheVolume("go-mod")).
+			WithEnvVariable("GOMODCACHE", "/go/pkg/mod").
+			// An empty build cache per attempt, so nothing is reused between builds
+			WithEnvVariable("GOCACHE", "/tmp/go-build").
+			WithEnvVariable("SOURCE_DATE_EPOCH", sourceDateEpoch).
+			WithEnvVariable("BUILD_ATTEMPT", strconv.Itoa(attempt)).
+			WithDirectory(workdir, source).
+			WithWorkdir(workdir)
+		return m.binary(ctr, "app", env, info)
+	}
+
+	hasher := m.Base().
+		WithNewFile("/tmp/sections/main.go", sectionHashes).
+		WithMountedFile("/first", build(1)).
+		WithMountedFile("/second", build(2))
+
+	firstOut, err := hasher.WithExec([]string{"go", "run", "/tmp/sections/main.go", "/first"}).Stdout(ctx)
+	if err != nil {
+		return "", err
+	}
+	secondOut, err := hasher.WithExec([]string{"go", "run", "/tmp/sections/main.go", "/second"}).Stdout(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	firstSha, firstSections := parseSectionHashes(firstOut)
+	secondSha, secondSections := parseSectionHashes(secondOut)
+
+	if firstSha == secondSha {
+		return fmt.Sprintf("Build is reproducible: sha256 %s", firstSha), nil
+	}
+
+	names := map[string]bool{}
+	for name := range firstSections {
+		names[name] = true
+	}
+	for name := range secondSections {
+		names[name] = true
+	}
+
+	var differing []string
+	for _, name := range sortedKeys(names) {
+		a, b := firstSections[name], secondSections[name]
+		if a != b {
+			differing = append(differing, fmt.Sprintf("%s (%d/%d bytes)", name, a.size, b.size))
+		}
+	}
+
+	return "", fmt.Errorf("build is not reproducible: sha256 %s != %s, differing sections: %s",
+		firstSha, secondSha, strings.Join(differing, ", "))
+}
+
+// parseSectionHashes parses the output of the section hashing program
+func parseSectionHashes(out string) (string, map[string]sectionHash) {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	sections := map[string]sectionHash{}
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		size, _ := strconv.Atoi(fields[2])
+		sections[fields[0]] = sectionHash{sha256: fields[1], size: size}
+	}
+	return lines[0], sections
+}
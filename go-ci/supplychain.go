@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+
+	"dagger/go-ci/internal/dagger"
+)
+
+const (
+	// renovate: datasource=go depName=golang.org/x/vuln
+	govulncheckVersion = "v1.1.4"
+	// renovate: datasource=go depName=github.com/google/go-licenses/v2
+	goLicensesVersion = "v2.0.1"
+)
+
+// defaultAllowedLicenses matches the +default of Licenses, for calls that bypass Dagger such as RunAllTests
+var defaultAllowedLicenses = []string{"Apache-2.0", "BSD-2-Clause", "BSD-3-Clause", "ISC", "MIT", "MPL-2.0"}
+
+// VulncheckReport lists the vulnerabilities reachable from the module's code
+type VulncheckReport struct {
+	// Vulnerabilities with at least one reachable symbol
+	Vulnerabilities []*Vulnerability
+}
+
+// Vulnerability is a vulnerability in a dependency that the code calls into
+type Vulnerability struct {
+	// Go vulnerability ID (e.g., "GO-2024-2687")
+	Id string
+	// CVE and GHSA aliases
+	Aliases []string
+	// Short description
+	Summary string
+	// Vulnerable module (e.g., "golang.org/x/net", "stdlib")
+	Module string
+	// Version of the module in use
+	FoundVersion string
+	// First version with a fix, empty if there is none
+	FixedVersion string
+	// Reachable vulnerable symbols (e.g., "http2.Framer.ReadFrame")
+	Symbols []string
+}
+
+// err returns an error naming the vulnerabilities, if any
+func (r *VulncheckReport) err() error {
+	if len(r.Vulnerabilities) == 0 {
+		return nil
+	}
+
+	var ids []string
+	for _, v := range r.Vulnerabilities {
+		ids = append(ids, fmt.Sprintf("%s (%s %s)", v.Id, v.Module, v.FoundVersion))
+	}
+	return fmt.Errorf("%d reachable vulnerabilities: %s", len(ids), strings.Join(ids, ", "))
+}
+
+// LicenseReport lists the licenses of the module's dependencies
+type LicenseReport struct {
+	Dependencies []*DependencyLicense
+}
+
+// DependencyLicense is the license of a single dependency
+type DependencyLicense struct {
+	// Module or package path
+	Module string
+	// SPDX license identifier, "Unknown" if it could not be detected
+	License string
+	// URL of the license file
+	Url string
+}
+
+// withTool returns the source container with a Go tool installed
+func (m *GoCi) withTool(source *dagger.Directory, pkg string) *dagger.Container {
+	return m.withSource(source).
+		WithExec([]string{"go", "install", pkg})
+}
+
+// Vulncheck runs govulncheck and returns the vulnerabilities reachable from the code
+//
+// Vulnerabilities in dependencies that are imported but never called are not reported.
+//
+// Example: govulncheck -format json ./...
+func (m *GoCi) Vulncheck(
+	ctx context.Context,
+	// +defaultPath="/"
+	source *dagger.Directory,
+) (*VulncheckReport, error) {
+	out, err := m.withTool(source, "golang.org/x/vuln/cmd/govulncheck@"+govulncheckVersion).
+		WithExec([]string{"govulncheck", "-format", "json", "./..."}).
+		Stdout(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseVulncheck(out)
+}
+
+// vulncheckMessage is a single message of govulncheck -format json output
+type vulncheckMessage struct {
+	Osv *struct {
+		Id      string   `json:"id"`
+		Aliases []string `json:"aliases"`
+		Summary string   `json:"summary"`
+	} `json:"osv"`
+	Finding *struct {
+		Osv          string `json:"osv"`
+		FixedVersion string `json:"fixed_version"`
+		Trace        []struct {
+			Module   string `json:"module"`
+			Version  string `json:"version"`
+			Package  string `json:"package"`
+			Function string `json:"function"`
+			Receiver string `json:"receiver"`
+		} `json:"trace"`
+	} `json:"finding"`
+}
+
+// parseVulncheck parses the stream of JSON messages written by govulncheck
+func parseVulncheck(out string) (*VulncheckReport, error) {
+	osvs := map[string]*Vulnerability{}
+	reachable := map[string]*Vulnerability{}
+
+	decoder := json.NewDecoder(strings.NewReader(out))
+	for {
+		var msg vulncheckMessage
+		if err := decoder.Decode(&msg); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to parse govulncheck output: %w", err)
+		}
+
+		if msg.Osv != nil {
+			osvs[msg.Osv.Id] = &Vulnerability{
+				Id:      msg.Osv.Id,
+				Aliases: msg.Osv.Aliases,
+				Summary: msg.Osv.Summary,
+			}
+		}
+
+		// Only findings with a function in the trace are reachable; the others
+		// are vulnerable modules or packages that are never called
+		f := msg.Finding
+		if f == nil || len(f.Trace) == 0 || f.Trace[0].Function == "" {
+			continue
+		}
+
+		v := reachable[f.Osv]
+		if v == nil {
+			v = &Vulnerability{Id: f.Osv}
+			if osv := osvs[f.Osv]; osv != nil {
+				v.Aliases = osv.Aliases
+				v.Summary = osv.Summary
+			}
+			v.Module = f.Trace[0].Module
+			v.FoundVersion = f.Trace[0].Version
+			v.FixedVersion = f.FixedVersion
+			reachable[f.Osv] = v
+		}
+
+		symbol := f.Trace[0].Function
+		if f.Trace[0].Receiver != "" {
+			symbol = strings.TrimPrefix(f.Trace[0].Receiver, "*") + "." + symbol
+		}
+		symbol = f.Trace[0].Package[strings.LastIndex(f.Trace[0].Package, "/")+1:] + "." + symbol
+		if !slices.Contains(v.Symbols, symbol) {
+			v.Symbols = append(v.Symbols, symbol)
+		}
+	}
+
+	report := &VulncheckReport{}
+	for _, id := range sortedKeys(reachable) {
+		sort.Strings(reachable[id].Symbols)
+		report.Vulnerabilities = append(report.Vulnerabilities, reachable[id])
+	}
+	return report, nil
+}
+
+// Licenses lists the licenses of all dependencies and fails on licenses that are not allowed
+//
+// Example: go-licenses report ./...
+func (m *GoCi) Licenses(
+	ctx context.Context,
+	// +defaultPath="/"
+	source *dagger.Directory,
+	// SPDX identifiers of the allowed licenses
+	// +default=["Apache-2.0", "BSD-2-Clause", "BSD-3-Clause", "ISC", "MIT", "MPL-2.0"]
+	allowed []string,
+) (*LicenseReport, error) {
+	ctr := m.withTool(source, "github.com/google/go-licenses/v2@"+goLicensesVersion)
+
+	module, err := ctr.WithExec([]string{"go", "list", "-m"}).Stdout(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// The module itself is not a dependency
+	out, err := ctr.
+		WithExec([]string{"go-licenses", "report", "--ignore", strings.TrimSpace(module), "./..."}).
+		Stdout(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := csv.NewReader(strings.NewReader(out)).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse go-licenses output: %w", err)
+	}
+
+	report := &LicenseReport{}
+	var disallowed []string
+	for _, record := range records {
+		if len(record) != 3 {
+			continue
+		}
+		dep := &DependencyLicense{Module: record[0], Url: record[1], License: record[2]}
+		report.Dependencies = append(report.Dependencies, dep)
+
+		if !slices.Contains(allowed, dep.License) {
+			disallowed = append(disallowed, fmt.Sprintf("%s (%s)", dep.Module, dep.License))
+		}
+	}
+
+	if len(disallowed) > 0 {
+		return nil, fmt.Errorf("%d dependencies with disallowed licenses: %s", len(disallowed), strings.Join(disallowed, ", "))
+	}
+	return report, nil
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"dagger/go-ci/internal/dagger"
+)
+
+// TestReport is the result of a go test run
+type TestReport struct {
+	// Number of passed tests, including subtests
+	Passed int
+	// Number of failed tests, including subtests
+	Failed int
+	// Number of skipped tests, including subtests
+	Skipped int
+	// Percentage of statements covered, 0 when coverage was not collected
+	Coverage float64
+	// Raw coverage profile (coverage.out), unset when coverage was not collected
+	CoverProfile *dagger.File
+	// Test results in JUnit XML format
+	Junit *dagger.File
+	// Failed tests, and packages that failed outside of a test (e.g., build errors)
+	Failures []*TestFailure
+}
+
+// TestFailure is a failed test or package
+type TestFailure struct {
+	// Import path of the package
+	Package string
+	// Test name, empty if the package failed outside of a test
+	Test string
+	// Output of the test
+	Output string
+}
+
+// Summary returns a one-line summary of the report
+func (r *TestReport) Summary() string {
+	summary := fmt.Sprintf("%d passed, %d failed, %d skipped", r.Passed, r.Failed, r.Skipped)
+	if r.CoverProfile != nil {
+		summary += fmt.Sprintf(", %.1f%% coverage", r.Coverage)
+	}
+	return summary
+}
+
+// err returns an error naming the failures, if any
+func (r *TestReport) err() error {
+	if len(r.Failures) == 0 {
+		return nil
+	}
+
+	var names []string
+	for _, f := range r.Failures {
+		if f.Test == "" {
+			names = append(names, f.Package)
+		} else {
+			names = append(names, f.Package+"."+f.Test)
+		}
+	}
+	return fmt.Errorf("tests failed (%s): %s", r.Summary(), strings.Join(names, ", "))
+}
+
+// Test runs go test and reports the results
+//
+// Failing tests do not make the function fail: the report lists them and
+// contains the JUnit XML file for CI systems to display.
+//
+// Example: go test -json -race -coverprofile=coverage.out ./...
+func (m *GoCi) Test(
+	ctx context.Context,
+	// +defaultPath="/"
+	source *dagger.Directory,
+	// Packages to test
+	// +default=["./..."]
+	packages []string,
+	// +optional
+	// Enable the race detector (requires cgo)
+	race bool,
+	// +optional
+	// Collect a coverage profile
+	// +default=true
+	coverProfile bool,
+) (*TestReport, error) {
+	ctr := m.withSource(source)
+
+	args := []string{"go", "test", "-json"}
+	if race {
+		ctr = ctr.
+			WithExec([]string{"apk", "add", "--no-cache", "build-base"}).
+			WithEnvVariable("CGO_ENABLED", "1")
+		args = append(args, "-race")
+	}
+	if coverProfile {
+		args = append(args, "-covermode=atomic", "-coverprofile=/out/coverage.out")
+	}
+	args = append(args, packages...)
+
+	ctr = ctr.
+		WithExec([]string{"mkdir", "-p", "/out"}).
+		WithExec(args, dagger.ContainerWithExecOpts{
+			Expect: dagger.ReturnTypeAny,
+		})
+
+	out, err := ctr.Stdout(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report, suites := parseTestEvents(out)
+
+	// A non-zero exit without failed packages means go test itself failed (e.g., no packages)
+	exitCode, err := ctr.ExitCode(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if exitCode != 0 && len(report.Failures) == 0 {
+		stderr, _ := ctr.Stderr(ctx)
+		return nil, fmt.Errorf("go test failed with exit code %d: %s", exitCode, stderr)
+	}
+
+	junit, err := xml.MarshalIndent(suites, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	report.Junit = dag.Directory().
+		WithNewFile("junit.xml", xml.Header+string(junit)).
+		File("junit.xml")
+
+	if coverProfile {
+		report.CoverProfile = ctr.File("/out/coverage.out")
+		profile, err := report.CoverProfile.Contents(ctx)
+		if err != nil {
+			return nil, err
+		}
+		report.Coverage = coveragePercent(profile)
+	}
+
+	return report, nil
+}
+
+// testEvent is a single event of go test -json output
+type testEvent struct {
+	Action      string
+	Package     string
+	ImportPath  string
+	Test        string
+	Elapsed     float64
+	Output      string
+	FailedBuild string
+}
+
+// junitTestSuites is the root element of a JUnit XML report
+type junitTestSuites struct {
+	XMLName xml.Name          `xml:"testsuites"`
+	Suites  []*junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Cases    []*junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Output  string `xml:",chardata"`
+}
+
+// parseTestEvents builds a report and JUnit test suites from go test -json output
+func parseTestEvents(out string) (*TestReport, *junitTestSuites) {
+	report := &TestReport{}
+	suites := map[string]*junitTestSuite{}
+	output := map[string]*strings.Builder{}
+
+	suite := func(pkg string) *junitTestSuite {
+		if suites[pkg] == nil {
+			suites[pkg] = &junitTestSuite{Name: pkg}
+		}
+		return suites[pkg]
+	}
+	appendOutput := func(key, line string) {
+		if output[key] == nil {
+			output[key] = &strings.Builder{}
+		}
+		output[key].WriteString(line)
+	}
+	outputOf := func(key string) string {
+		if output[key] == nil {
+			return ""
+		}
+		return output[key].String()
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var e testEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// Not an event, e.g. output of a test binary writing to stdout directly
+			continue
+		}
+
+		key := e.Package + " " + e.Test
+		switch e.Action {
+		case "output":
+			appendOutput(key, e.Output)
+		case "build-output":
+			appendOutput(e.ImportPath+" ", e.Output)
+		case "pass", "fail", "skip":
+			elapsed := strconv.FormatFloat(e.Elapsed, 'f', 3, 64)
+			s := suite(e.Package)
+
+			if e.Test == "" {
+				s.Time = elapsed
+				// A package failing without a failed test did not build or crashed
+				if e.Action == "fail" && s.Failures == 0 {
+					log := outputOf(key)
+					if e.FailedBuild != "" {
+						log = outputOf(e.FailedBuild+" ") + log
+					}
+					report.Failures = append(report.Failures, &TestFailure{
+						Package: e.Package,
+						Output:  log,
+					})
+
+					// A synthetic test case, so the suite is not shown as passing
+					name := "[package failed]"
+					if e.FailedBuild != "" {
+						name = "[build failed]"
+					}
+					s.Tests++
+					s.Failures++
+					s.Cases = append(s.Cases, &junitTestCase{
+						Name:      name,
+						Classname: e.Package,
+						Time:      elapsed,
+						Failure:   &junitMessage{Message: "Failed", Output: log},
+					})
+				}
+				continue
+			}
+
+			tc := &junitTestCase{Name: e.Test, Classname: e.Package, Time: elapsed}
+			s.Tests++
+			switch e.Action {
+			case "pass":
+				report.Passed++
+			case "fail":
+				report.Failed++
+				s.Failures++
+				tc.Failure = &junitMessage{Message: "Failed", Output: outputOf(key)}
+				report.Failures = append(report.Failures, &TestFailure{
+					Package: e.Package,
+					Test:    e.Test,
+					Output:  outputOf(key),
+				})
+			case "skip":
+				report.Skipped++
+				s.Skipped++
+				tc.Skipped = &junitMessage{Message: "Skipped", Output: outputOf(key)}
+			}
+			s.Cases = append(s.Cases, tc)
+		}
+	}
+
+	result := &junitTestSuites{}
+	for _, name := range sortedKeys(suites) {
+		result.Suites = append(result.Suites, suites[name])
+	}
+	return report, result
+}
+
+// coveragePercent computes the percentage of covered statements from a coverage profile
+func coveragePercent(profile string) float64 {
+	type block struct {
+		statements int
+		covered    bool
+	}
+	// Blocks can appear once per package that covers them, so merge by position
+	blocks := map[string]*block{}
+
+	for _, line := range strings.Split(profile, "\n") {
+		if line == "" || strings.HasPrefix(line, "mode:") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		statements, err1 := strconv.Atoi(fields[1])
+		count, err2 := strconv.Atoi(fields[2])
+		if err1 != nil || err2 != nil {
+			continue
+		}
+
+		b := blocks[fields[0]]
+		if b == nil {
+			b = &block{statements: statements}
+			blocks[fields[0]] = b
+		}
+		b.covered = b.covered || count > 0
+	}
+
+	var total, covered int
+	for _, b := range blocks {
+		total += b.statements
+		if b.covered {
+			covered += b.statements
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(covered) * 100 / float64(total)
+}
+
+// sortedKeys returns the keys of a map in sorted order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -7,7 +7,9 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"dagger/miele-ci/internal/dagger"
@@ -15,6 +17,9 @@ import (
 	"golang.org/x/sync/errgroup"
 )
 
+// renovate: datasource=docker depName=nginx
+const nginxImage = "nginx:1.27.5-alpine3.21@sha256:65645c7bb6a0661892a8b03b89d0743208a18dd2f3f17a54ef4b76fb8e2f2a10"
+
 func New(
 	// +defaultPath="/fixtures/miele-delay-start"
 	source *dagger.Directory,
@@ -24,12 +29,24 @@ func New(
 	tag string,
 	// +default="staticaland"
 	ghcrUsername string,
+	// Maximum number of CRITICAL vulnerabilities allowed (negative disables the check)
+	// +default=0
+	maxCritical int,
+	// Maximum number of HIGH vulnerabilities allowed (negative disables the check)
+	// +default=-1
+	maxHigh int,
+	// Builder identity recorded in SLSA provenance (e.g., the CI workflow running the build)
+	// +default="https://dagger.io"
+	builderId string,
 ) *MieleCi {
 	return &MieleCi{
 		Source:       source,
 		ImageName:    imageName,
 		Tag:          tag,
 		GhcrUsername: ghcrUsername,
+		MaxCritical:  maxCritical,
+		MaxHigh:      maxHigh,
+		BuilderId:    builderId,
 	}
 }
 
@@ -38,6 +55,23 @@ type MieleCi struct {
 	ImageName    string
 	Tag          string
 	GhcrUsername string
+	MaxCritical  int
+	MaxHigh      int
+	BuilderId    string
+}
+
+// PublishedImage is an image published to GHCR together with its supply-chain artifacts
+type PublishedImage struct {
+	// Published image address, pinned by digest
+	Address string
+	// CycloneDX SBOMs, one per platform (e.g., sbom-linux-amd64.cdx.json)
+	Sboms *dagger.Directory
+	// Reference of the cosign signature, empty when the image was not signed
+	Signature string
+	// In-toto SLSA provenance statement describing how the image was built
+	Provenance *dagger.File
+	// Reference of the cosign provenance attestation, empty when the image was not signed
+	Attestation string
 }
 
 // notify sends a notification via ntfy and logs any errors without failing
@@ -118,29 +152,35 @@ func (m *MieleCi) VerifyArtifact(ctx context.Context) ([]*dagger.Container, erro
 		"linux/arm64",
 	}
 
+	// Record the base image so crane rebase can find it without --old_base
+	baseName, baseDigest, _ := strings.Cut(nginxImage, "@")
+
 	platformVariants := make([]*dagger.Container, 0, len(platforms))
 	for _, platform := range platforms {
 		ctr := dag.Container(dagger.ContainerOpts{Platform: platform}).
-			// renovate: datasource=docker depName=nginx
-			From("nginx:1.27.5-alpine3.21@sha256:65645c7bb6a0661892a8b03b89d0743208a18dd2f3f17a54ef4b76fb8e2f2a10").
+			From(nginxImage).
 			WithDirectory("/usr/share/nginx/html", builtSite).
 			WithExposedPort(80).
 			WithLabel("org.opencontainers.image.title", m.ImageName).
 			WithLabel("org.opencontainers.image.version", m.Tag).
 			WithLabel("org.opencontainers.image.created", time.Now().String()).
-			WithLabel("org.opencontainers.image.source", "https://github.com/staticaland/athame")
+			WithLabel("org.opencontainers.image.source", "https://github.com/staticaland/athame").
+			WithAnnotation("org.opencontainers.image.base.name", "docker.io/library/"+baseName).
+			WithAnnotation("org.opencontainers.image.base.digest", baseDigest)
 
 		platformVariants = append(platformVariants, ctr)
 	}
 
-	// Step 3: Scan the first platform variant (amd64)
+	// Step 3: Scan every platform variant and gate on the merged result
 	m.notify(ctx, "Scanning container for vulnerabilities...", dagger.NtfySendOpts{
 		Title:    "Verify: Scan",
 		Priority: "default",
 		Tags:     "shield",
 	})
 
-	scanResult, err := dag.Trivy().ScanContainer(ctx, platformVariants[0], "scan-target")
+	// Pick up the trivy ignore file and VEX document from the source directory
+	report := dag.Trivy(dagger.TrivyOpts{Policy: m.Source}).ScanContainers(platformVariants)
+	summary, err := report.Summary(ctx)
 	if err != nil {
 		m.notify(ctx, "Check logs for details.", dagger.NtfySendOpts{
 			Title:    "Verify: Scan Failed",
@@ -149,7 +189,25 @@ func (m *MieleCi) VerifyArtifact(ctx context.Context) ([]*dagger.Container, erro
 		})
 		return nil, fmt.Errorf("trivy scan failed: %w", err)
 	}
-	fmt.Printf("Trivy scan results:\n%s\n", scanResult)
+	fmt.Printf("Trivy scan results: %s\n", summary)
+
+	warnings, err := report.Warnings(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("trivy scan failed: %w", err)
+	}
+	for _, warning := range warnings {
+		fmt.Printf("Trivy warning: %s\n", warning)
+	}
+
+	// Fail on policy rather than on trivy's exit code
+	if err := report.Gate(ctx, m.MaxCritical, m.MaxHigh); err != nil {
+		m.notify(ctx, summary, dagger.NtfySendOpts{
+			Title:    "Verify: Vulnerability Policy Failed",
+			Priority: "high",
+			Tags:     "warning",
+		})
+		return nil, fmt.Errorf("trivy gate failed: %w", err)
+	}
 
 	m.notify(ctx, "Verification completed successfully.", dagger.NtfySendOpts{
 		Title:    "Verify: Completed",
@@ -172,16 +230,27 @@ func (m *MieleCi) Build() *dagger.Directory {
 }
 
 // Publish runs VerifyArtifact, then publishes the verified containers to GHCR
+// Returns the published address together with an SBOM for each platform
+// An in-toto SLSA provenance statement records how the image was built
+// The published digest is signed with cosign, and its provenance attested, when a key is provided
 // This phase requires GHCR token for authentication
 func (m *MieleCi) Publish(
 	ctx context.Context,
 	// GitHub token for GHCR authentication (get with: gh auth token)
 	ghcrToken *dagger.Secret,
-) (string, error) {
+	// +optional
+	// Cosign private key; the published digest is signed when set
+	cosignKey *dagger.Secret,
+	// +optional
+	// Password of the cosign private key
+	cosignPassword *dagger.Secret,
+) (*PublishedImage, error) {
+	startedOn := time.Now()
+
 	// Phase 1: VerifyArtifact (build + test + scan) - returns built containers
 	platformVariants, err := m.VerifyArtifact(ctx)
 	if err != nil {
-		return "", fmt.Errorf("verify artifact phase failed: %w", err)
+		return nil, fmt.Errorf("verify artifact phase failed: %w", err)
 	}
 
 	// Phase 2: Publish the verified containers to GHCR
@@ -203,7 +272,56 @@ func (m *MieleCi) Publish(
 			Priority: "high",
 			Tags:     "warning",
 		})
-		return "", fmt.Errorf("failed to publish to GHCR: %w", err)
+		return nil, fmt.Errorf("failed to publish to GHCR: %w", err)
+	}
+
+	// Record how the image was built
+	nodeImageTag, err := dag.Node().ImageTag(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	parameters, err := json.Marshal(map[string]any{
+		"imageName":    m.ImageName,
+		"tag":          m.Tag,
+		"ghcrUsername": m.GhcrUsername,
+		"maxCritical":  m.MaxCritical,
+		"maxHigh":      m.MaxHigh,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	provenance := dag.Provenance().WithRegistryAuth("ghcr.io", m.GhcrUsername, ghcrToken)
+	statement := provenance.Generate(addr, "MieleCi.Publish", m.Source, m.BuilderId, startedOn.UTC().Format(time.RFC3339), dagger.ProvenanceGenerateOpts{
+		PlatformVariants: platformVariants,
+		Parameters:       string(parameters),
+		Dependencies:     []string{nginxImage, "node:" + nodeImageTag},
+	})
+
+	// The subject is the index digest rather than the tag, which can move
+	subject, err := statement.Subject(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("provenance generation failed: %w", err)
+	}
+
+	var signature, attestation string
+	if cosignKey != nil {
+		signed := provenance.Sign(subject, statement.Predicate(), cosignKey, dagger.ProvenanceSignOpts{
+			Password: cosignPassword,
+		})
+		signature, err = signed.Signature(ctx)
+		if err == nil {
+			attestation, err = signed.Attestation(ctx)
+		}
+		if err != nil {
+			m.notify(ctx, "Check logs for details.", dagger.NtfySendOpts{
+				Title:    "Publish: Signing Failed",
+				Priority: "high",
+				Tags:     "warning",
+			})
+			return nil, fmt.Errorf("failed to sign image: %w", err)
+		}
 	}
 
 	m.notify(ctx,
@@ -215,7 +333,13 @@ func (m *MieleCi) Publish(
 			Markdown: true,
 		})
 
-	return addr, nil
+	return &PublishedImage{
+		Address:     addr,
+		Sboms:       dag.Trivy().Sboms(platformVariants),
+		Signature:   signature,
+		Provenance:  statement.Statement(),
+		Attestation: attestation,
+	}, nil
 }
 
 // Deploy runs Publish, then deploys the container image to Fly.io
@@ -231,6 +355,12 @@ func (m *MieleCi) Deploy(
 	// +optional
 	// +default="arn"
 	flyioRegion string,
+	// +optional
+	// Cosign private key; the published digest is signed when set
+	cosignKey *dagger.Secret,
+	// +optional
+	// Password of the cosign private key
+	cosignPassword *dagger.Secret,
 ) (string, error) {
 	m.notify(ctx, "Starting CI/CD pipeline...", dagger.NtfySendOpts{
 		Title:    "Miele CI/CD Started",
@@ -239,10 +369,11 @@ func (m *MieleCi) Deploy(
 	})
 
 	// Phase 1+2: Publish (which calls VerifyArtifact)
-	addr, err := m.Publish(ctx, ghcrToken)
+	published, err := m.Publish(ctx, ghcrToken, cosignKey, cosignPassword)
 	if err != nil {
 		return "", fmt.Errorf("publish phase failed: %w", err)
 	}
+	addr := published.Address
 
 	// Phase 3: Deploy to Fly.io
 	m.notify(ctx, "Deploying to Fly.io...", dagger.NtfySendOpts{
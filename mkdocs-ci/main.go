@@ -7,7 +7,9 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"dagger/mkdocs-ci/internal/dagger"
@@ -15,6 +17,9 @@ import (
 	"golang.org/x/sync/errgroup"
 )
 
+// renovate: datasource=docker depName=nginx
+const nginxImage = "nginx:1.27.5-alpine3.21@sha256:65645c7bb6a0661892a8b03b89d0743208a18dd2f3f17a54ef4b76fb8e2f2a10"
+
 func New(
 	// +defaultPath="/"
 	source *dagger.Directory,
@@ -26,6 +31,15 @@ func New(
 	tag string,
 	// +default="staticaland"
 	ghcrUsername string,
+	// Maximum number of CRITICAL vulnerabilities allowed (negative disables the check)
+	// +default=0
+	maxCritical int,
+	// Maximum number of HIGH vulnerabilities allowed (negative disables the check)
+	// +default=-1
+	maxHigh int,
+	// Builder identity recorded in SLSA provenance (e.g., the CI workflow running the build)
+	// +default="https://dagger.io"
+	builderId string,
 ) *MkdocsCi {
 	return &MkdocsCi{
 		Source:       source,
@@ -33,6 +47,9 @@ func New(
 		ImageName:    imageName,
 		Tag:          tag,
 		GhcrUsername: ghcrUsername,
+		MaxCritical:  maxCritical,
+		MaxHigh:      maxHigh,
+		BuilderId:    builderId,
 	}
 }
 
@@ -42,6 +59,25 @@ type MkdocsCi struct {
 	ImageName    string
 	Tag          string
 	GhcrUsername string
+	MaxCritical  int
+	MaxHigh      int
+	BuilderId    string
+}
+
+// PublishedImage is an image published to GHCR together with its supply-chain artifacts
+type PublishedImage struct {
+	// Published image address, pinned by digest
+	Address string
+	// CycloneDX SBOMs, one per platform (e.g., sbom-linux-amd64.cdx.json)
+	Sboms *dagger.Directory
+	// Reference of the cosign signature, empty when the image was not signed
+	Signature string
+	// In-toto SLSA provenance statement describing how the image was built
+	Provenance *dagger.File
+	// Reference of the cosign provenance attestation, empty when the image was not signed
+	Attestation string
+	// Provenance, SBOMs and scan reports attached to the image as OCI referrers, pinned by digest
+	Attachments []string
 }
 
 // notify sends a notification via ntfy and logs any errors without failing
@@ -129,29 +165,35 @@ func (m *MkdocsCi) VerifyArtifact(
 		"linux/arm64", // For Apple Silicon Macs and ARM servers
 	}
 
+	// Record the base image so crane rebase can find it without --old_base
+	baseName, baseDigest, _ := strings.Cut(nginxImage, "@")
+
 	platformVariants := make([]*dagger.Container, 0, len(platforms))
 	for _, platform := range platforms {
 		ctr := dag.Container(dagger.ContainerOpts{Platform: platform}).
-			// renovate: datasource=docker depName=nginx
-			From("nginx:1.27.5-alpine3.21@sha256:65645c7bb6a0661892a8b03b89d0743208a18dd2f3f17a54ef4b76fb8e2f2a10").
+			From(nginxImage).
 			WithDirectory("/usr/share/nginx/html", builtSite).
 			WithExposedPort(80).
 			WithLabel("org.opencontainers.image.title", m.ImageName).
 			WithLabel("org.opencontainers.image.version", m.Tag).
 			WithLabel("org.opencontainers.image.created", time.Now().String()).
-			WithLabel("org.opencontainers.image.source", "https://github.com/staticaland/athame")
+			WithLabel("org.opencontainers.image.source", "https://github.com/staticaland/athame").
+			WithAnnotation("org.opencontainers.image.base.name", "docker.io/library/"+baseName).
+			WithAnnotation("org.opencontainers.image.base.digest", baseDigest)
 
 		platformVariants = append(platformVariants, ctr)
 	}
 
-	// Step 3: Scan the first platform variant (amd64)
+	// Step 3: Scan every platform variant and gate on the merged result
 	m.notify(ctx, "Scanning container for vulnerabilities...", dagger.NtfySendOpts{
 		Title:    "Verify: Scan",
 		Priority: "default",
 		Tags:     "shield",
 	})
 
-	scanResult, err := dag.Trivy().ScanContainer(ctx, platformVariants[0], "scan-target")
+	// Pick up the trivy ignore file and VEX document from the source directory
+	report := dag.Trivy(dagger.TrivyOpts{Policy: m.Source}).ScanContainers(platformVariants)
+	summary, err := report.Summary(ctx)
 	if err != nil {
 		m.notify(ctx, "Check logs for details.", dagger.NtfySendOpts{
 			Title:    "Verify: Scan Failed",
@@ -160,7 +202,25 @@ func (m *MkdocsCi) VerifyArtifact(
 		})
 		return nil, fmt.Errorf("trivy scan failed: %w", err)
 	}
-	fmt.Printf("Trivy scan results:\n%s\n", scanResult)
+	fmt.Printf("Trivy scan results: %s\n", summary)
+
+	warnings, err := report.Warnings(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("trivy scan failed: %w", err)
+	}
+	for _, warning := range warnings {
+		fmt.Printf("Trivy warning: %s\n", warning)
+	}
+
+	// Fail on policy rather than on trivy's exit code
+	if err := report.Gate(ctx, m.MaxCritical, m.MaxHigh); err != nil {
+		m.notify(ctx, summary, dagger.NtfySendOpts{
+			Title:    "Verify: Vulnerability Policy Failed",
+			Priority: "high",
+			Tags:     "warning",
+		})
+		return nil, fmt.Errorf("trivy gate failed: %w", err)
+	}
 
 	m.notify(ctx, "Verification completed successfully.", dagger.NtfySendOpts{
 		Title:    "Verify: Completed",
@@ -306,17 +366,94 @@ func (m *MkdocsCi) Build() *dagger.Directory {
 	})
 }
 
+// attachArtifacts attaches the provenance, and an SBOM and a trivy report for each platform, to the published image
+//
+// The artifacts are linked through the OCI referrers API, so they can be
+// listed from the image with: oras discover <image>
+func (m *MkdocsCi) attachArtifacts(
+	ctx context.Context,
+	// Published image, pinned by digest without a tag
+	subject string,
+	platformVariants []*dagger.Container,
+	provenance *dagger.File,
+	ghcrToken *dagger.Secret,
+) ([]string, error) {
+	trivy := dag.Trivy(dagger.TrivyOpts{Policy: m.Source})
+	oras := dag.Oras().WithRegistryAuth("ghcr.io", m.GhcrUsername, ghcrToken)
+
+	attachments := make([]string, 2*len(platformVariants)+1)
+	eg, gctx := errgroup.WithContext(ctx)
+
+	eg.Go(func() error {
+		ref, err := oras.Attach(gctx, subject, provenance, "application/vnd.in-toto+json")
+		if err != nil {
+			return fmt.Errorf("failed to attach provenance: %w", err)
+		}
+		attachments[len(attachments)-1] = ref
+		return nil
+	})
+
+	for i, ctr := range platformVariants {
+		platform, err := ctr.Platform(ctx)
+		if err != nil {
+			return nil, err
+		}
+		suffix := strings.ReplaceAll(string(platform), "/", "-")
+
+		sbom := trivy.Sbom(ctr).
+			WithName(fmt.Sprintf("sbom-%s.cdx.json", suffix))
+		report := trivy.ScanContainer(ctr, "scan-"+suffix, dagger.TrivyScanContainerOpts{
+			Format: dagger.TrivyScanFormatJson,
+		}).File().
+			WithName(fmt.Sprintf("trivy-%s.json", suffix))
+
+		eg.Go(func() error {
+			ref, err := oras.Attach(gctx, subject, sbom, "application/vnd.cyclonedx+json")
+			if err != nil {
+				return fmt.Errorf("failed to attach SBOM for %s: %w", platform, err)
+			}
+			attachments[2*i] = ref
+			return nil
+		})
+		eg.Go(func() error {
+			ref, err := oras.Attach(gctx, subject, report, "application/vnd.aquasec.trivy.report+json")
+			if err != nil {
+				return fmt.Errorf("failed to attach trivy report for %s: %w", platform, err)
+			}
+			attachments[2*i+1] = ref
+			return nil
+		})
+	}
+
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
 // Publish runs VerifyArtifact, then publishes the verified containers to GHCR
+// Returns the published address together with an SBOM for each platform
+// An in-toto SLSA provenance statement records how the image was built
+// The published digest is signed with cosign, and its provenance attested, when a key is provided
+// The SBOMs and trivy reports are also attached to the image digest as OCI referrers
 // This phase requires GHCR token for authentication
 func (m *MkdocsCi) Publish(
 	ctx context.Context,
 	// GitHub token for GHCR authentication (get with: gh auth token)
 	ghcrToken *dagger.Secret,
-) (string, error) {
+	// +optional
+	// Cosign private key; the published digest is signed when set
+	cosignKey *dagger.Secret,
+	// +optional
+	// Password of the cosign private key
+	cosignPassword *dagger.Secret,
+) (*PublishedImage, error) {
+	startedOn := time.Now()
+
 	// Phase 1: VerifyArtifact (lint + build + scan) - returns built containers
 	platformVariants, err := m.VerifyArtifact(ctx)
 	if err != nil {
-		return "", fmt.Errorf("verify artifact phase failed: %w", err)
+		return nil, fmt.Errorf("verify artifact phase failed: %w", err)
 	}
 
 	// Phase 2: Publish the verified containers to GHCR
@@ -338,7 +475,62 @@ func (m *MkdocsCi) Publish(
 			Priority: "high",
 			Tags:     "warning",
 		})
-		return "", fmt.Errorf("failed to publish to GHCR: %w", err)
+		return nil, fmt.Errorf("failed to publish to GHCR: %w", err)
+	}
+
+	// Record how the image was built
+	mkdocsImageTag, err := dag.MkdocsMaterial().ImageTag(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	parameters, err := json.Marshal(map[string]any{
+		"sitePath":     m.SitePath,
+		"imageName":    m.ImageName,
+		"tag":          m.Tag,
+		"ghcrUsername": m.GhcrUsername,
+		"maxCritical":  m.MaxCritical,
+		"maxHigh":      m.MaxHigh,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	provenance := dag.Provenance().WithRegistryAuth("ghcr.io", m.GhcrUsername, ghcrToken)
+	statement := provenance.Generate(addr, "MkdocsCi.Publish", m.Source, m.BuilderId, startedOn.UTC().Format(time.RFC3339), dagger.ProvenanceGenerateOpts{
+		PlatformVariants: platformVariants,
+		Parameters:       string(parameters),
+		Dependencies:     []string{nginxImage, "squidfunk/mkdocs-material:" + mkdocsImageTag},
+	})
+
+	// The subject is the index digest rather than the tag, which can move
+	subject, err := statement.Subject(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("provenance generation failed: %w", err)
+	}
+
+	attachments, err := m.attachArtifacts(ctx, subject, platformVariants, statement.Statement(), ghcrToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to attach supply-chain artifacts: %w", err)
+	}
+
+	var signature, attestation string
+	if cosignKey != nil {
+		signed := provenance.Sign(subject, statement.Predicate(), cosignKey, dagger.ProvenanceSignOpts{
+			Password: cosignPassword,
+		})
+		signature, err = signed.Signature(ctx)
+		if err == nil {
+			attestation, err = signed.Attestation(ctx)
+		}
+		if err != nil {
+			m.notify(ctx, "Check logs for details.", dagger.NtfySendOpts{
+				Title:    "Publish: Signing Failed",
+				Priority: "high",
+				Tags:     "warning",
+			})
+			return nil, fmt.Errorf("failed to sign image: %w", err)
+		}
 	}
 
 	m.notify(ctx,
@@ -350,7 +542,14 @@ func (m *MkdocsCi) Publish(
 			Markdown: true,
 		})
 
-	return addr, nil
+	return &PublishedImage{
+		Address:     addr,
+		Sboms:       dag.Trivy().Sboms(platformVariants),
+		Signature:   signature,
+		Provenance:  statement.Statement(),
+		Attestation: attestation,
+		Attachments: attachments,
+	}, nil
 }
 
 // Deploy runs Publish, then deploys the container image to cloud platforms
@@ -385,6 +584,12 @@ func (m *MkdocsCi) Deploy(
 	// +default="europe-north2"
 	// Artifact Registry region (can be different from Cloud Run region)
 	artifactRegistryRegion string,
+	// +optional
+	// Cosign private key; the published digest is signed when set
+	cosignKey *dagger.Secret,
+	// +optional
+	// Password of the cosign private key
+	cosignPassword *dagger.Secret,
 ) (string, error) {
 	m.notify(ctx, "Starting CI/CD pipeline...", dagger.NtfySendOpts{
 		Title:    "MkDocs CI/CD Started",
@@ -393,10 +598,11 @@ func (m *MkdocsCi) Deploy(
 	})
 
 	// Phase 1+2: Publish (which calls VerifyArtifact)
-	addr, err := m.Publish(ctx, ghcrToken)
+	published, err := m.Publish(ctx, ghcrToken, cosignKey, cosignPassword)
 	if err != nil {
 		return "", fmt.Errorf("publish phase failed: %w", err)
 	}
+	addr := published.Address
 
 	// Phase 3: Deploy to cloud platforms
 	m.notify(ctx, "Deploying to cloud platforms...", dagger.NtfySendOpts{
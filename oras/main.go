@@ -1,9 +1,18 @@
 // A Dagger module for ORAS (OCI Registry As Storage)
+//
+// ORAS pushes and pulls arbitrary artifacts (static sites, Terraform plans,
+// SBOMs, ...) to and from OCI registries, next to container images.
+//
+// Use WithRegistryAuth to authenticate against one or more private registries.
 
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"strings"
+	"time"
 
 	"dagger/oras/internal/dagger"
 )
@@ -12,19 +21,308 @@ func New(
 	// renovate: datasource=docker depName=ghcr.io/oras-project/oras
 	// +default="v1.3.0@sha256:6ce045ce069a89934d6666b8b49f9c4c0145201bd6de6dbe2aee267814c55468"
 	imageTag string,
+	// +optional
+	// Use plain HTTP for registries (e.g., a local registry:2 service)
+	plainHttp bool,
 ) *Oras {
 	return &Oras{
-		ImageTag: imageTag,
+		ImageTag:  imageTag,
+		PlainHttp: plainHttp,
 	}
 }
 
 type Oras struct {
-	ImageTag string
+	ImageTag      string
+	PlainHttp     bool
+	RegistryAuths []*RegistryAuth
+	Services      []*ServiceBinding
+}
+
+// RegistryAuth holds credentials for a single registry
+type RegistryAuth struct {
+	// Registry host (e.g., "ghcr.io")
+	Registry string
+	// Registry username
+	Username string
+	// Registry password or token
+	Password *dagger.Secret
+}
+
+// ServiceBinding is a service reachable from the ORAS container
+type ServiceBinding struct {
+	// Hostname the service is reachable at
+	Alias string
+	// Service to bind (e.g., a registry:2 container)
+	Service *dagger.Service
+}
+
+// WithRegistryAuth adds credentials for a registry
+//
+// Can be called several times to authenticate against multiple registries.
+func (m *Oras) WithRegistryAuth(
+	// Registry host (e.g., "ghcr.io")
+	registry string,
+	// Registry username
+	username string,
+	// Registry password or token
+	password *dagger.Secret,
+) *Oras {
+	m.RegistryAuths = append(m.RegistryAuths, &RegistryAuth{
+		Registry: registry,
+		Username: username,
+		Password: password,
+	})
+	return m
+}
+
+// WithServiceBinding makes a service (e.g., a local registry) reachable under the given hostname
+func (m *Oras) WithServiceBinding(
+	// Hostname the service is reachable at (e.g., "registry")
+	alias string,
+	// Service to bind
+	service *dagger.Service,
+) *Oras {
+	m.Services = append(m.Services, &ServiceBinding{
+		Alias:   alias,
+		Service: service,
+	})
+	return m
 }
 
 // Base returns the base container with ORAS installed
 func (m *Oras) Base() *dagger.Container {
-	return dag.Container().
+	ctr := dag.Container().
 		From(fmt.Sprintf("ghcr.io/oras-project/oras:%s", m.ImageTag)).
 		WithoutEntrypoint()
+
+	for _, binding := range m.Services {
+		ctr = ctr.WithServiceBinding(binding.Alias, binding.Service)
+	}
+
+	return ctr
+}
+
+// authenticated returns the base container with a docker config for all registry credentials
+func (m *Oras) authenticated() *dagger.Container {
+	config := dag.DockerConfig()
+	for _, a := range m.RegistryAuths {
+		config = config.WithRegistryAuth(a.Registry, a.Username, a.Password)
+	}
+
+	return config.Mount(m.Base().WithEnvVariable("CACHEBUSTER", time.Now().String()))
+}
+
+// command adds global flags to an oras command
+func (m *Oras) command(args []string) []string {
+	if !m.PlainHttp {
+		return args
+	}
+	// oras cp talks to two registries and has a flag for each
+	if args[1] == "cp" {
+		return append(args, "--from-plain-http", "--to-plain-http")
+	}
+	return append(args, "--plain-http")
+}
+
+// Push pushes the contents of a directory as an OCI artifact
+//
+// Each top-level entry becomes a layer; subdirectories are archived by ORAS.
+// Returns the digest-pinned artifact reference.
+//
+// Example: oras push registry/repo:tag --artifact-type application/vnd.example --annotation key=value file...
+func (m *Oras) Push(
+	ctx context.Context,
+	// Artifact reference (e.g., "ghcr.io/org/site:1.0")
+	reference string,
+	// Files to push
+	files *dagger.Directory,
+	// +optional
+	// Artifact type (e.g., "application/vnd.mkdocs.site")
+	artifactType string,
+	// +optional
+	// Manifest annotations in key=value form
+	annotations []string,
+) (string, error) {
+	entries, err := files.Entries(ctx)
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 0 {
+		return "", fmt.Errorf("nothing to push: directory is empty")
+	}
+
+	args := []string{"oras", "push", reference, "--format", "json"}
+	if artifactType != "" {
+		args = append(args, "--artifact-type", artifactType)
+	}
+	for _, annotation := range annotations {
+		args = append(args, "--annotation", annotation)
+	}
+	for _, entry := range entries {
+		args = append(args, strings.TrimSuffix(entry, "/"))
+	}
+
+	ctr := m.authenticated()
+
+	out, err := ctr.
+		WithMountedDirectory("/artifact", files).
+		WithWorkdir("/artifact").
+		WithExec(m.command(args)).
+		Stdout(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return pinnedReference(reference, out)
+}
+
+// Pull pulls the files of an OCI artifact into a directory
+//
+// Example: oras pull registry/repo:tag --output /out
+func (m *Oras) Pull(
+	ctx context.Context,
+	// Artifact reference (e.g., "ghcr.io/org/site:1.0")
+	reference string,
+) (*dagger.Directory, error) {
+	ctr := m.authenticated()
+
+	return ctr.
+		WithExec(m.command([]string{"oras", "pull", reference, "--output", "/out"})).
+		Directory("/out"), nil
+}
+
+// Copy copies an artifact or image between registries
+//
+// Unlike crane copy, a recursive copy brings along the referrers of the
+// artifact (signatures, SBOMs, attestations), so supply-chain metadata
+// survives mirroring. Returns the digest-pinned destination reference.
+//
+// Example: oras cp -r ghcr.io/org/app:1.0 registry.example.com/app:1.0
+func (m *Oras) Copy(
+	ctx context.Context,
+	// Source reference (e.g., "ghcr.io/org/app:1.0")
+	source string,
+	// Destination reference (e.g., "europe-docker.pkg.dev/project/repo/app:1.0")
+	destination string,
+	// +optional
+	// Copy the referrers of the artifact as well
+	// +default=true
+	recursive bool,
+) (string, error) {
+	args := []string{"oras", "cp", source, destination}
+	if recursive {
+		args = append(args, "--recursive")
+	}
+
+	ctr := m.authenticated()
+
+	digest, err := ctr.
+		WithExec(m.command(args)).
+		WithExec(m.command([]string{"oras", "resolve", destination})).
+		Stdout(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return repository(destination) + "@" + strings.TrimSpace(digest), nil
+}
+
+// pinnedReference returns the digest-pinned reference from oras --format json output
+func pinnedReference(reference, out string) (string, error) {
+	var descriptor struct {
+		Digest string `json:"digest"`
+	}
+	if err := json.Unmarshal([]byte(out), &descriptor); err != nil {
+		return "", fmt.Errorf("failed to parse oras output: %w", err)
+	}
+	if descriptor.Digest == "" {
+		return "", fmt.Errorf("oras output has no digest: %s", out)
+	}
+
+	return repository(reference) + "@" + descriptor.Digest, nil
+}
+
+// repository strips the tag or digest from a reference
+//
+// Example: ghcr.io/org/app:1.0@sha256:abc -> ghcr.io/org/app
+func repository(reference string) string {
+	if i := strings.Index(reference, "@"); i >= 0 {
+		reference = reference[:i]
+	}
+	if i := strings.LastIndex(reference, ":"); i > strings.LastIndex(reference, "/") {
+		reference = reference[:i]
+	}
+	return reference
+}
+
+// Attach attaches a file to a subject image as a referrer artifact
+//
+// The artifact is linked to the subject digest through the OCI referrers API,
+// so signatures, SBOMs and scan reports can be found from the image.
+// Returns the digest-pinned reference of the attached artifact.
+//
+// Example: oras attach registry/repo@sha256:... --artifact-type application/vnd.cyclonedx+json sbom.json
+func (m *Oras) Attach(
+	ctx context.Context,
+	// Subject image reference, ideally pinned by digest
+	subject string,
+	// File to attach
+	file *dagger.File,
+	// Artifact type (e.g., "application/vnd.cyclonedx+json")
+	artifactType string,
+	// +optional
+	// Manifest annotations in key=value form
+	annotations []string,
+) (string, error) {
+	name, err := file.Name(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	args := []string{"oras", "attach", subject, "--artifact-type", artifactType, "--format", "json"}
+	for _, annotation := range annotations {
+		args = append(args, "--annotation", annotation)
+	}
+	args = append(args, name)
+
+	ctr := m.authenticated()
+
+	out, err := ctr.
+		WithMountedFile("/artifact/"+name, file).
+		WithWorkdir("/artifact").
+		WithExec(m.command(args)).
+		Stdout(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return pinnedReference(subject, out)
+}
+
+// Discover lists the referrers of a subject image as a tree
+//
+// Example: oras discover registry/repo@sha256:... --format json
+func (m *Oras) Discover(
+	ctx context.Context,
+	// Subject image reference
+	subject string,
+	// +optional
+	// Only list referrers of this artifact type
+	artifactType string,
+) (*ReferrerTree, error) {
+	args := []string{"oras", "discover", subject, "--format", "json"}
+	if artifactType != "" {
+		args = append(args, "--artifact-type", artifactType)
+	}
+
+	ctr := m.authenticated()
+
+	out, err := ctr.
+		WithExec(m.command(args)).
+		Stdout(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseReferrerTree(subject, out)
 }
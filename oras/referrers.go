@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// ReferrerTree is a subject image and the artifacts that refer to it
+type ReferrerTree struct {
+	// Subject image reference
+	Subject string
+	// Subject digest
+	Digest string
+	// Artifacts referring to the subject
+	Referrers []*Referrer
+}
+
+// Referrer is an artifact attached to a subject, such as a signature or SBOM
+type Referrer struct {
+	// Digest-pinned reference of the artifact
+	Reference string
+	// Artifact digest
+	Digest string
+	// Manifest media type
+	MediaType string
+	// Artifact type (e.g., "application/vnd.cyclonedx+json")
+	ArtifactType string
+	// Manifest size in bytes
+	Size int
+	// Manifest annotations in key=value form
+	Annotations []string
+	// Artifacts referring to this artifact (e.g., a signature of an SBOM)
+	Referrers []*Referrer
+}
+
+// Find returns all referrers in the tree with the given artifact type
+func (t *ReferrerTree) Find(
+	// Artifact type (e.g., "application/vnd.cyclonedx+json")
+	artifactType string,
+) []*Referrer {
+	var found []*Referrer
+	var walk func([]*Referrer)
+	walk = func(referrers []*Referrer) {
+		for _, r := range referrers {
+			if r.ArtifactType == artifactType {
+				found = append(found, r)
+			}
+			walk(r.Referrers)
+		}
+	}
+	walk(t.Referrers)
+	return found
+}
+
+// descriptorJSON is a node of oras discover --format json output
+type descriptorJSON struct {
+	Reference    string            `json:"reference"`
+	MediaType    string            `json:"mediaType"`
+	Digest       string            `json:"digest"`
+	Size         int               `json:"size"`
+	ArtifactType string            `json:"artifactType"`
+	Annotations  map[string]string `json:"annotations"`
+	// Nested referrers (oras 1.3 and later)
+	Referrers []*descriptorJSON `json:"referrers"`
+	// Flat list of direct referrers (oras 1.2)
+	Manifests []*descriptorJSON `json:"manifests"`
+}
+
+// parseReferrerTree parses oras discover --format json output
+func parseReferrerTree(subject, out string) (*ReferrerTree, error) {
+	var root descriptorJSON
+	if err := json.Unmarshal([]byte(out), &root); err != nil {
+		return nil, fmt.Errorf("failed to parse oras output: %w", err)
+	}
+
+	return &ReferrerTree{
+		Subject:   subject,
+		Digest:    root.Digest,
+		Referrers: referrers(subject, &root),
+	}, nil
+}
+
+// referrers converts the children of a descriptor
+func referrers(subject string, d *descriptorJSON) []*Referrer {
+	var result []*Referrer
+	for _, child := range append(d.Referrers, d.Manifests...) {
+		reference := child.Reference
+		if reference == "" {
+			reference = repository(subject) + "@" + child.Digest
+		}
+
+		var annotations []string
+		for key, value := range child.Annotations {
+			annotations = append(annotations, key+"="+value)
+		}
+		sort.Strings(annotations)
+
+		result = append(result, &Referrer{
+			Reference:    reference,
+			Digest:       child.Digest,
+			MediaType:    child.MediaType,
+			ArtifactType: child.ArtifactType,
+			Size:         child.Size,
+			Annotations:  annotations,
+			Referrers:    referrers(subject, child),
+		})
+	}
+	return result
+}
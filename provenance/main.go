@@ -0,0 +1,277 @@
+// A Dagger module for SLSA provenance of images built by Dagger functions
+//
+// Generates in-toto SLSA v1 provenance statements for published images, and
+// signs the images and attests their provenance with cosign.
+//
+// Use WithRegistryAuth to authenticate against the registry the image was published to.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"dagger/provenance/internal/dagger"
+)
+
+// buildType identifies builds run by a Dagger function of this repository
+const buildType = "https://github.com/staticaland/athame/dagger-function/v1"
+
+func New() *Provenance {
+	return &Provenance{}
+}
+
+type Provenance struct {
+	RegistryAuths []*RegistryAuth
+}
+
+// RegistryAuth holds credentials for a single registry
+type RegistryAuth struct {
+	// Registry host (e.g., "ghcr.io")
+	Registry string
+	// Registry username
+	Username string
+	// Registry password or token
+	Password *dagger.Secret
+}
+
+// ProvenanceFiles holds an in-toto statement and its SLSA provenance predicate
+type ProvenanceFiles struct {
+	// Image the statement is about, pinned by digest (e.g., ghcr.io/org/app@sha256:...)
+	Subject string
+	// In-toto statement with the image as subject (provenance.intoto.json)
+	Statement *dagger.File
+	// SLSA provenance predicate alone, as expected by cosign attest
+	Predicate *dagger.File
+}
+
+// SignedImage holds the references cosign pushed for a signed image
+type SignedImage struct {
+	// Reference of the cosign signature
+	Signature string
+	// Reference of the cosign provenance attestation
+	Attestation string
+}
+
+// resourceDescriptor is an in-toto ResourceDescriptor
+type resourceDescriptor struct {
+	Name   string            `json:"name,omitempty"`
+	URI    string            `json:"uri,omitempty"`
+	Digest map[string]string `json:"digest"`
+}
+
+// WithRegistryAuth adds credentials for a registry
+//
+// Can be called several times to authenticate against multiple registries.
+func (m *Provenance) WithRegistryAuth(
+	// Registry host (e.g., "ghcr.io")
+	registry string,
+	// Registry username
+	username string,
+	// Registry password or token
+	password *dagger.Secret,
+) *Provenance {
+	m.RegistryAuths = append(m.RegistryAuths, &RegistryAuth{
+		Registry: registry,
+		Username: username,
+		Password: password,
+	})
+	return m
+}
+
+// Generate generates an in-toto SLSA v1 provenance statement for a published image
+//
+// The function name, the source digest and the platforms are recorded as
+// external parameters next to the given ones.
+func (m *Provenance) Generate(
+	ctx context.Context,
+	// Published image address, pinned by digest (e.g., ghcr.io/org/app:latest@sha256:...)
+	image string,
+	// Dagger function that built the image (e.g., "MkdocsCi.Publish")
+	function string,
+	// Source directory the image was built from
+	source *dagger.Directory,
+	// Builder identity (e.g., the CI workflow running the build)
+	builderId string,
+	// When the build started, in RFC 3339 format
+	startedOn string,
+	// Repository URI the source directory comes from
+	// +default="https://github.com/staticaland/athame"
+	repository string,
+	// +optional
+	// Platform variants of the image
+	platformVariants []*dagger.Container,
+	// +optional
+	// Other external parameters of the function as a JSON object (e.g., {"tag":"latest"})
+	parameters string,
+	// +optional
+	// Images the build depends on, pinned by digest (e.g., nginx:1.27.5-alpine3.21@sha256:...)
+	dependencies []string,
+) (*ProvenanceFiles, error) {
+	subject := digestReference(image)
+	repo, digest, ok := strings.Cut(subject, "@")
+	if !ok {
+		return nil, fmt.Errorf("image is not pinned by digest: %s", image)
+	}
+
+	externalParameters := map[string]any{}
+	if parameters != "" {
+		if err := json.Unmarshal([]byte(parameters), &externalParameters); err != nil {
+			return nil, fmt.Errorf("parameters are not a JSON object: %w", err)
+		}
+	}
+
+	sourceDigest, err := source.Digest(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to digest source: %w", err)
+	}
+
+	var platforms []string
+	for _, ctr := range platformVariants {
+		platform, err := ctr.Platform(ctx)
+		if err != nil {
+			return nil, err
+		}
+		platforms = append(platforms, string(platform))
+	}
+
+	externalParameters["function"] = function
+	externalParameters["source"] = resourceDescriptor{
+		URI:    repository,
+		Digest: digestMap(sourceDigest),
+	}
+	externalParameters["platforms"] = platforms
+
+	resolvedDependencies := []resourceDescriptor{}
+	for _, dependency := range dependencies {
+		resolvedDependencies = append(resolvedDependencies, imageDependency(dependency))
+	}
+
+	engineVersion, err := dag.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	predicate := map[string]any{
+		"buildDefinition": map[string]any{
+			"buildType":            buildType,
+			"externalParameters":   externalParameters,
+			"resolvedDependencies": resolvedDependencies,
+		},
+		"runDetails": map[string]any{
+			"builder": map[string]any{
+				"id": builderId,
+				"version": map[string]string{
+					"dagger": engineVersion,
+				},
+			},
+			"metadata": map[string]any{
+				"startedOn":  startedOn,
+				"finishedOn": time.Now().UTC().Format(time.RFC3339),
+			},
+		},
+	}
+
+	statement := map[string]any{
+		"_type": "https://in-toto.io/Statement/v1",
+		"subject": []resourceDescriptor{{
+			Name:   repo,
+			Digest: digestMap(digest),
+		}},
+		"predicateType": "https://slsa.dev/provenance/v1",
+		"predicate":     predicate,
+	}
+
+	statementJSON, err := json.MarshalIndent(statement, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	predicateJSON, err := json.MarshalIndent(predicate, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	files := dag.Directory().
+		WithNewFile("provenance.intoto.json", string(statementJSON)).
+		WithNewFile("provenance.predicate.json", string(predicateJSON))
+
+	return &ProvenanceFiles{
+		Subject:   subject,
+		Statement: files.File("provenance.intoto.json"),
+		Predicate: files.File("provenance.predicate.json"),
+	}, nil
+}
+
+// Sign signs a published image digest with cosign and attests its provenance
+func (m *Provenance) Sign(
+	ctx context.Context,
+	// Published image address, pinned by digest (e.g., ghcr.io/org/app:latest@sha256:...)
+	image string,
+	// SLSA provenance predicate (the Predicate of Generate)
+	predicate *dagger.File,
+	// Cosign private key (cosign.key)
+	key *dagger.Secret,
+	// +optional
+	// Password of the cosign private key
+	password *dagger.Secret,
+) (*SignedImage, error) {
+	cosign := dag.Cosign()
+	for _, a := range m.RegistryAuths {
+		cosign = cosign.WithRegistryAuth(a.Registry, a.Username, a.Password)
+	}
+
+	// Sign the digest rather than the tag, which can move
+	subject := digestReference(image)
+
+	signature, err := cosign.Sign(ctx, subject, key, dagger.CosignSignOpts{
+		Password: password,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	attestation, err := cosign.Attest(ctx, subject, predicate, key, dagger.CosignAttestOpts{
+		PredicateType: "slsaprovenance1",
+		Password:      password,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to attest provenance: %w", err)
+	}
+
+	return &SignedImage{
+		Signature:   signature,
+		Attestation: attestation,
+	}, nil
+}
+
+// digestReference strips the tag from a published address, keeping the digest
+//
+// Example: ghcr.io/org/app:latest@sha256:abc -> ghcr.io/org/app@sha256:abc
+func digestReference(addr string) string {
+	repo, digest, ok := strings.Cut(addr, "@")
+	if !ok {
+		return addr
+	}
+	if i := strings.LastIndex(repo, ":"); i > strings.LastIndex(repo, "/") {
+		repo = repo[:i]
+	}
+	return repo + "@" + digest
+}
+
+// digestMap converts "sha256:abc" to an in-toto digest set
+func digestMap(digest string) map[string]string {
+	algorithm, hex, _ := strings.Cut(digest, ":")
+	return map[string]string{algorithm: hex}
+}
+
+// imageDependency describes a pinned image (name:tag@sha256:...) as a resolved dependency
+func imageDependency(image string) resourceDescriptor {
+	ref, digest, _ := strings.Cut(image, "@")
+	return resourceDescriptor{
+		URI:    "pkg:docker/" + strings.Replace(ref, ":", "@", 1),
+		Digest: digestMap(digest),
+	}
+}
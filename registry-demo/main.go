@@ -0,0 +1,111 @@
+// A Dagger module that demonstrates the registry modules against a local registry
+//
+// This module starts a registry:2 service and runs the crane and oras modules
+// against it, so registry workflows can be tried without credentials or a
+// remote registry.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"dagger/registry-demo/internal/dagger"
+)
+
+// renovate: datasource=docker depName=registry
+const registryImage = "registry:2"
+
+// renovate: datasource=docker depName=alpine
+const sourceImage = "alpine:3.22.2@sha256:4b7ce07002c69e8f3d704a9c5d6fd3053be500b7f1c69fc0d80990c2ad8dd412"
+
+type RegistryDemo struct{}
+
+// registry returns a local registry:2 service, reachable as registry:5000 once bound
+func (m *RegistryDemo) registry() *dagger.Service {
+	return dag.Container().
+		From(registryImage).
+		WithExposedPort(5000).
+		AsService()
+}
+
+// TestPromote promotes a multi-platform image between repositories and verifies its digest
+func (m *RegistryDemo) TestPromote(ctx context.Context) (string, error) {
+	// Keep the registry running across steps, as a restarted registry loses its images
+	registry, err := m.registry().Start(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer registry.Stop(ctx)
+
+	crane := dag.Crane(dagger.CraneOpts{Insecure: true}).
+		WithServiceBinding("registry", registry)
+
+	// Seed the registry with a multi-platform image
+	source := "registry:5000/staging/alpine:3.22.2"
+	if _, err := crane.Copy(ctx, sourceImage, source); err != nil {
+		return "", err
+	}
+
+	digest, err := crane.Digest(ctx, source)
+	if err != nil {
+		return "", err
+	}
+	digest = strings.TrimSpace(digest)
+
+	promoted, err := crane.Promote(ctx, source, "registry:5000/production/alpine:3.22.2", dagger.CranePromoteOpts{
+		Tags: []string{"3.22", "latest"},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if expected := "registry:5000/production/alpine@" + digest; promoted != expected {
+		return "", fmt.Errorf("expected %s, got %s", expected, promoted)
+	}
+
+	return promoted, nil
+}
+
+// TestPushPull pushes a directory as an OCI artifact, pulls it back and compares the contents
+func (m *RegistryDemo) TestPushPull(ctx context.Context) (string, error) {
+	registry, err := m.registry().Start(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer registry.Stop(ctx)
+
+	oras := dag.Oras(dagger.OrasOpts{PlainHttp: true}).
+		WithServiceBinding("registry", registry)
+
+	files := map[string]string{
+		"index.html":        "<h1>Demo</h1>\n",
+		"assets/styles.css": "h1 { color: rebeccapurple; }\n",
+	}
+	site := dag.Directory()
+	for path, contents := range files {
+		site = site.WithNewFile(path, contents)
+	}
+
+	reference, err := oras.Push(ctx, "registry:5000/demo/site:1.0", site, dagger.OrasPushOpts{
+		ArtifactType: "application/vnd.athame.demo.site",
+		Annotations:  []string{"org.opencontainers.image.title=demo-site"},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	pulled := oras.Pull(reference)
+	for path, expected := range files {
+		contents, err := pulled.File(path).Contents(ctx)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s from %s: %w", path, reference, err)
+		}
+		if contents != expected {
+			return "", fmt.Errorf("%s differs after pull: expected %q, got %q", path, expected, contents)
+		}
+	}
+
+	return reference, nil
+}
@@ -1,6 +1,12 @@
 // Wrapper for Trivy CLI
-// Scans container images for vulnerabilities
+// Scans container images, filesystems, IaC config and SBOMs for vulnerabilities
+// Generates CycloneDX and SPDX SBOMs from Dagger containers
+// Supports offline scans against a pinned vulnerability DB
+// Exports scan results as table, JSON, SARIF or JUnit files
 // Uses official Trivy image
+//
+// The *Report functions parse Trivy's JSON output into a ScanReport
+// that can be gated on severity counts.
 
 package main
 
@@ -8,30 +14,266 @@ import (
 	"context"
 	"fmt"
 	"strconv"
+	"strings"
+	"time"
 
 	"dagger/trivy/internal/dagger"
+
+	"golang.org/x/sync/errgroup"
 )
 
 func New(
 	// renovate: datasource=docker depName=aquasec/trivy
 	// +default="0.67.2@sha256:e2b22eac59c02003d8749f5b8d9bd073b62e30fefaef5b7c8371204e0a4b0c08"
 	imageTag string,
+	// +optional
+	// Pre-fetched vulnerability DB directory containing trivy.db and metadata.json (see DownloadDb)
+	db *dagger.Directory,
+	// +optional
+	// Pre-fetched vulnerability DB archive: the db.tar.gz layer (e.g., from: oras pull ghcr.io/aquasecurity/trivy-db:2) or a tarball of the whole OCI image layout (e.g., from: crane pull --format oci ghcr.io/aquasecurity/trivy-db:2)
+	dbArchive *dagger.File,
+	// +optional
+	// Use the cached vulnerability DB as-is and skip all other downloads during scans
+	skipDbUpdate bool,
+	// +optional
+	// Directory holding the scan policy: an ignore file (.trivyignore.yaml or .trivyignore) and a VEX document (.openvex.json), used by scans that are not given their own
+	policy *dagger.Directory,
 ) *Trivy {
 	return &Trivy{
-		ImageTag: imageTag,
+		ImageTag:     imageTag,
+		Db:           db,
+		DbArchive:    dbArchive,
+		SkipDbUpdate: skipDbUpdate,
+		Policy:       policy,
 	}
 }
 
 // Wrapper for Trivy CLI
 type Trivy struct {
-	ImageTag string
+	ImageTag     string
+	Db           *dagger.Directory
+	DbArchive    *dagger.File
+	SkipDbUpdate bool
+	Policy       *dagger.Directory
 }
 
 // Base returns the base container with Trivy installed
+//
+// When a pre-fetched DB is provided, it is used instead of the shared
+// trivy-db-cache volume and all downloads are skipped, so scans work offline
+// and are reproducible against a known DB snapshot.
 func (m *Trivy) Base() *dagger.Container {
+	ctr := dag.Container().
+		From(fmt.Sprintf("aquasec/trivy:%s", m.ImageTag))
+
+	switch {
+	case m.Db != nil:
+		return offline(ctr.
+			WithDirectory("/root/.cache/trivy/db", m.Db))
+	case m.DbArchive != nil:
+		return offline(ctr.
+			WithMountedFile("/tmp/trivy-db.tar", m.DbArchive).
+			WithExec([]string{"sh", "-c", extractDbArchive}))
+	}
+
+	ctr = ctr.WithMountedCache("/root/.cache/trivy", dag.CacheVolume("trivy-db-cache"))
+	if m.SkipDbUpdate {
+		ctr = offline(ctr)
+	}
+
+	return ctr
+}
+
+// extractDbArchive extracts the DB from /tmp/trivy-db.tar into the trivy cache
+//
+// An OCI image layout is searched for the blob holding trivy.db, which is the
+// db.tar.gz layer of the trivy-db artifact.
+const extractDbArchive = `set -e
+mkdir -p /root/.cache/trivy/db
+if tar -tzf /tmp/trivy-db.tar 2>/dev/null | grep -q 'trivy.db$'; then
+	tar -xzf /tmp/trivy-db.tar -C /root/.cache/trivy/db
+	exit 0
+fi
+mkdir -p /tmp/trivy-db-oci
+tar -xf /tmp/trivy-db.tar -C /tmp/trivy-db-oci
+for blob in /tmp/trivy-db-oci/blobs/*/*; do
+	if tar -tzf "$blob" 2>/dev/null | grep -q 'trivy.db$'; then
+		tar -xzf "$blob" -C /root/.cache/trivy/db
+		exit 0
+	fi
+done
+echo "no trivy.db found in the DB archive" >&2
+exit 1
+`
+
+// offline disables every download trivy does during a scan
+func offline(ctr *dagger.Container) *dagger.Container {
+	return ctr.
+		WithEnvVariable("TRIVY_SKIP_DB_UPDATE", "true").
+		// Misconfiguration checks bundle used by config scans
+		WithEnvVariable("TRIVY_SKIP_CHECK_UPDATE", "true").
+		// Java DB, downloaded as soon as an image contains jars
+		WithEnvVariable("TRIVY_SKIP_JAVA_DB_UPDATE", "true").
+		// Maven Central lookups for jars without metadata
+		WithEnvVariable("TRIVY_OFFLINE_SCAN", "true")
+}
+
+// DownloadDb downloads the latest vulnerability DB and returns it as a directory
+//
+// The result can be stored and passed back with --db for offline scans.
+//
+// Example: trivy image --download-db-only
+func (m *Trivy) DownloadDb() *dagger.Directory {
 	return dag.Container().
 		From(fmt.Sprintf("aquasec/trivy:%s", m.ImageTag)).
-		WithMountedCache("/root/.cache/trivy", dag.CacheVolume("trivy-db-cache"))
+		// Bust the cache so every call fetches the current DB
+		WithEnvVariable("CACHEBUSTER", time.Now().String()).
+		WithExec([]string{"trivy", "image", "--download-db-only", "--cache-dir", "/trivy"}).
+		Directory("/trivy/db")
+}
+
+// policy fills in the ignore file and VEX document from the policy directory, unless given
+func (m *Trivy) policy(ctx context.Context, ignoreFile, vex *dagger.File) (*dagger.File, *dagger.File, error) {
+	if m.Policy == nil {
+		return ignoreFile, vex, nil
+	}
+
+	if ignoreFile == nil {
+		for _, name := range []string{".trivyignore.yaml", ".trivyignore"} {
+			exists, err := m.Policy.Exists(ctx, name)
+			if err != nil {
+				return nil, nil, err
+			}
+			if exists {
+				ignoreFile = m.Policy.File(name)
+				break
+			}
+		}
+	}
+
+	if vex == nil {
+		exists, err := m.Policy.Exists(ctx, ".openvex.json")
+		if err != nil {
+			return nil, nil, err
+		}
+		if exists {
+			vex = m.Policy.File(".openvex.json")
+		}
+	}
+
+	return ignoreFile, vex, nil
+}
+
+// ScanFormat is the output format of a scan
+type ScanFormat string
+
+const (
+	// Human-readable table
+	ScanFormatTable ScanFormat = "table"
+	// Trivy JSON, as parsed by the *Report functions
+	ScanFormatJson ScanFormat = "json"
+	// SARIF, for code scanning alerts
+	ScanFormatSarif ScanFormat = "sarif"
+	// JUnit XML, rendered with trivy's built-in junit template
+	ScanFormatJunit ScanFormat = "junit"
+)
+
+// fileName returns the stable file name scan results are written to
+func (f ScanFormat) fileName() (string, error) {
+	switch f {
+	case ScanFormatTable:
+		return "trivy-results.txt", nil
+	case ScanFormatJson:
+		return "trivy-results.json", nil
+	case ScanFormatSarif:
+		return "trivy-results.sarif", nil
+	case ScanFormatJunit:
+		return "trivy-results.junit.xml", nil
+	}
+	return "", fmt.Errorf("unsupported scan format %q", f)
+}
+
+// args returns the trivy flags that select the format
+func (f ScanFormat) args() []string {
+	if f == ScanFormatJunit {
+		return []string{"--format", "template", "--template", "@/contrib/junit.tpl"}
+	}
+	return []string{"--format", string(f)}
+}
+
+// ScanOutput is the result of a scan in the requested format
+type ScanOutput struct {
+	// Contents of the scan results file
+	Contents string
+	// Scan results written to a file with a stable name (e.g., trivy-results.sarif)
+	File *dagger.File
+}
+
+// scan runs a trivy subcommand with the options shared by all scan functions
+func (m *Trivy) scan(
+	ctx context.Context,
+	ctr *dagger.Container,
+	// trivy subcommand and its target (e.g., "image", "--input", "/scan/app")
+	target []string,
+	severity string,
+	exitCode int,
+	format ScanFormat,
+	ignoreFile *dagger.File,
+	vex *dagger.File,
+) (*ScanOutput, error) {
+	name, err := format.fileName()
+	if err != nil {
+		return nil, err
+	}
+	output := "/out/" + name
+
+	args := []string{
+		"trivy",
+		target[0],
+		"--quiet",
+		"--severity", severity,
+		"--exit-code", strconv.Itoa(exitCode),
+		"--output", output,
+	}
+	args = append(args, format.args()...)
+
+	ignoreFile, policyVex, err := m.policy(ctx, ignoreFile, vex)
+	if err != nil {
+		return nil, err
+	}
+	// trivy config has no --vex flag
+	if target[0] != "config" {
+		vex = policyVex
+	}
+
+	if ignoreFile != nil {
+		ignoreName, err := ignoreFile.Name(ctx)
+		if err != nil {
+			return nil, err
+		}
+		// Keep the original name so trivy picks the YAML parser for .trivyignore.yaml
+		ctr = ctr.WithMountedFile("/ignore/"+ignoreName, ignoreFile)
+		args = append(args, "--ignorefile", "/ignore/"+ignoreName)
+	}
+
+	if vex != nil {
+		ctr = ctr.WithMountedFile("/vex/vex.json", vex)
+		args = append(args, "--vex", "/vex/vex.json")
+	}
+
+	file := ctr.
+		WithExec(append(args, target[1:]...)).
+		File(output)
+
+	contents, err := file.Contents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ScanOutput{
+		Contents: contents,
+		File:     file,
+	}, nil
 }
 
 // ScanImage scans a container image reference for vulnerabilities
@@ -46,19 +288,17 @@ func (m *Trivy) ScanImage(
 	exitCode int,
 	// +optional
 	// +default="table"
-	format string,
-) (string, error) {
-	return m.Base().
-		WithExec([]string{
-			"trivy",
-			"image",
-			"--quiet",
-			"--severity", severity,
-			"--exit-code", strconv.Itoa(exitCode),
-			"--format", format,
-			imageRef,
-		}).
-		Stdout(ctx)
+	format ScanFormat,
+	// +optional
+	// Trivy ignore file (.trivyignore or .trivyignore.yaml)
+	ignoreFile *dagger.File,
+	// +optional
+	// VEX document (OpenVEX, CycloneDX or CSAF) marking vulnerabilities as not affected
+	vex *dagger.File,
+) (*ScanOutput, error) {
+	return m.scan(ctx, m.Base(),
+		[]string{"image", imageRef},
+		severity, exitCode, format, ignoreFile, vex)
 }
 
 // ScanContainer scans a Dagger Container for vulnerabilities
@@ -74,18 +314,378 @@ func (m *Trivy) ScanContainer(
 	exitCode int,
 	// +optional
 	// +default="table"
+	format ScanFormat,
+	// +optional
+	// Trivy ignore file (.trivyignore or .trivyignore.yaml)
+	ignoreFile *dagger.File,
+	// +optional
+	// VEX document (OpenVEX, CycloneDX or CSAF) marking vulnerabilities as not affected
+	vex *dagger.File,
+) (*ScanOutput, error) {
+	return m.scan(ctx, m.Base().WithMountedFile("/scan/"+imageRef, ctr.AsTarball()),
+		[]string{"image", "--input", "/scan/" + imageRef},
+		severity, exitCode, format, ignoreFile, vex)
+}
+
+// ScanDirectory scans a source directory for vulnerable dependencies and secrets
+//
+// Example: trivy fs /src
+func (m *Trivy) ScanDirectory(
+	ctx context.Context,
+	// Directory to scan (e.g., a project with a package-lock.json or go.sum)
+	source *dagger.Directory,
+	// +optional
+	// +default="UNKNOWN,LOW,MEDIUM,HIGH,CRITICAL"
+	severity string,
+	// +optional
+	// +default=0
+	exitCode int,
+	// +optional
+	// +default="table"
+	format ScanFormat,
+	// +optional
+	// Trivy ignore file (.trivyignore or .trivyignore.yaml)
+	ignoreFile *dagger.File,
+	// +optional
+	// VEX document (OpenVEX, CycloneDX or CSAF) marking vulnerabilities as not affected
+	vex *dagger.File,
+) (*ScanOutput, error) {
+	return m.scan(ctx, m.Base().WithMountedDirectory("/src", source),
+		[]string{"fs", "/src"},
+		severity, exitCode, format, ignoreFile, vex)
+}
+
+// ScanConfig scans IaC files (Terraform, Kubernetes, Dockerfile, ...) for misconfigurations
+//
+// Example: trivy config /src
+func (m *Trivy) ScanConfig(
+	ctx context.Context,
+	// Directory containing IaC files (e.g., fixtures/terraform)
+	source *dagger.Directory,
+	// +optional
+	// +default="UNKNOWN,LOW,MEDIUM,HIGH,CRITICAL"
+	severity string,
+	// +optional
+	// +default=0
+	exitCode int,
+	// +optional
+	// +default="table"
+	format ScanFormat,
+	// +optional
+	// Trivy ignore file (.trivyignore or .trivyignore.yaml)
+	ignoreFile *dagger.File,
+) (*ScanOutput, error) {
+	return m.scan(ctx, m.Base().WithMountedDirectory("/src", source),
+		[]string{"config", "/src"},
+		severity, exitCode, format, ignoreFile, nil)
+}
+
+// ScanSbom scans an existing CycloneDX or SPDX SBOM for vulnerabilities
+//
+// Example: trivy sbom /scan/sbom.json
+func (m *Trivy) ScanSbom(
+	ctx context.Context,
+	// SBOM file in CycloneDX or SPDX JSON format
+	sbom *dagger.File,
+	// +optional
+	// +default="UNKNOWN,LOW,MEDIUM,HIGH,CRITICAL"
+	severity string,
+	// +optional
+	// +default=0
+	exitCode int,
+	// +optional
+	// +default="table"
+	format ScanFormat,
+	// +optional
+	// Trivy ignore file (.trivyignore or .trivyignore.yaml)
+	ignoreFile *dagger.File,
+	// +optional
+	// VEX document (OpenVEX, CycloneDX or CSAF) marking vulnerabilities as not affected
+	vex *dagger.File,
+) (*ScanOutput, error) {
+	return m.scan(ctx, m.Base().WithMountedFile("/scan/sbom.json", sbom),
+		[]string{"sbom", "/scan/sbom.json"},
+		severity, exitCode, format, ignoreFile, vex)
+}
+
+// Sbom generates an SBOM for a Dagger Container
+//
+// Example: trivy image --format cyclonedx --output sbom.cdx.json --input image.tar
+func (m *Trivy) Sbom(
+	ctr *dagger.Container,
+	// SBOM format: "cyclonedx" or "spdx-json"
+	// +optional
+	// +default="cyclonedx"
 	format string,
-) (string, error) {
+) (*dagger.File, error) {
+	extension, err := sbomExtension(format)
+	if err != nil {
+		return nil, err
+	}
+	output := "/out/sbom" + extension
+
 	return m.Base().
-		WithMountedFile("/scan/"+imageRef, ctr.AsTarball()).
+		WithMountedFile("/scan/image.tar", ctr.AsTarball()).
 		WithExec([]string{
 			"trivy",
 			"image",
 			"--quiet",
-			"--severity", severity,
-			"--exit-code", strconv.Itoa(exitCode),
 			"--format", format,
-			"--input", "/scan/" + imageRef,
+			"--output", output,
+			"--input", "/scan/image.tar",
 		}).
-		Stdout(ctx)
+		File(output), nil
+}
+
+// Sboms generates an SBOM for every platform variant of an image
+//
+// The SBOMs are named after their platform (e.g., sbom-linux-amd64.cdx.json).
+func (m *Trivy) Sboms(
+	ctx context.Context,
+	// Platform variants (e.g., the PlatformVariants passed to Publish)
+	ctrs []*dagger.Container,
+	// SBOM format: "cyclonedx" or "spdx-json"
+	// +optional
+	// +default="cyclonedx"
+	format string,
+) (*dagger.Directory, error) {
+	extension, err := sbomExtension(format)
+	if err != nil {
+		return nil, err
+	}
+
+	sboms := dag.Directory()
+	for _, ctr := range ctrs {
+		platform, err := ctr.Platform(ctx)
+		if err != nil {
+			return nil, err
+		}
+		sbom, err := m.Sbom(ctr, format)
+		if err != nil {
+			return nil, err
+		}
+		name := "sbom-" + strings.ReplaceAll(string(platform), "/", "-") + extension
+		sboms = sboms.WithFile(name, sbom)
+	}
+
+	return sboms, nil
+}
+
+// sbomExtension returns the file extension for an SBOM format
+func sbomExtension(format string) (string, error) {
+	switch format {
+	case "cyclonedx":
+		return ".cdx.json", nil
+	case "spdx-json":
+		return ".spdx.json", nil
+	}
+	return "", fmt.Errorf("unsupported SBOM format %q (expected \"cyclonedx\" or \"spdx-json\")", format)
+}
+
+// report parses JSON scan output and adds warnings about the ignore file
+func (m *Trivy) report(ctx context.Context, out *ScanOutput, ignoreFile *dagger.File) (*ScanReport, error) {
+	report, err := parseReport(out.Contents)
+	if err != nil {
+		return nil, err
+	}
+
+	report.Warnings, err = m.ignoreWarnings(ctx, ignoreFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// ignoreWarnings returns a warning for every expired entry in the ignore file
+func (m *Trivy) ignoreWarnings(ctx context.Context, ignoreFile *dagger.File) ([]string, error) {
+	ignoreFile, _, err := m.policy(ctx, ignoreFile, nil)
+	if err != nil {
+		return nil, err
+	}
+	if ignoreFile == nil {
+		return nil, nil
+	}
+
+	name, err := ignoreFile.Name(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	contents, err := ignoreFile.Contents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return expiredIgnores(name, contents, time.Now()), nil
+}
+
+// ScanImageReport scans a container image reference and returns a parsed report
+func (m *Trivy) ScanImageReport(
+	ctx context.Context,
+	imageRef string,
+	// +optional
+	// +default="UNKNOWN,LOW,MEDIUM,HIGH,CRITICAL"
+	severity string,
+	// +optional
+	// Trivy ignore file (.trivyignore or .trivyignore.yaml)
+	ignoreFile *dagger.File,
+	// +optional
+	// VEX document (OpenVEX, CycloneDX or CSAF) marking vulnerabilities as not affected
+	vex *dagger.File,
+) (*ScanReport, error) {
+	out, err := m.ScanImage(ctx, imageRef, severity, 0, ScanFormatJson, ignoreFile, vex)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.report(ctx, out, ignoreFile)
+}
+
+// ScanContainerReport scans a Dagger Container and returns a parsed report
+func (m *Trivy) ScanContainerReport(
+	ctx context.Context,
+	ctr *dagger.Container,
+	imageRef string,
+	// +optional
+	// +default="UNKNOWN,LOW,MEDIUM,HIGH,CRITICAL"
+	severity string,
+	// +optional
+	// Trivy ignore file (.trivyignore or .trivyignore.yaml)
+	ignoreFile *dagger.File,
+	// +optional
+	// VEX document (OpenVEX, CycloneDX or CSAF) marking vulnerabilities as not affected
+	vex *dagger.File,
+) (*ScanReport, error) {
+	out, err := m.ScanContainer(ctx, ctr, imageRef, severity, 0, ScanFormatJson, ignoreFile, vex)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.report(ctx, out, ignoreFile)
+}
+
+// ScanDirectoryReport scans a source directory and returns a parsed report
+func (m *Trivy) ScanDirectoryReport(
+	ctx context.Context,
+	source *dagger.Directory,
+	// +optional
+	// +default="UNKNOWN,LOW,MEDIUM,HIGH,CRITICAL"
+	severity string,
+	// +optional
+	// Trivy ignore file (.trivyignore or .trivyignore.yaml)
+	ignoreFile *dagger.File,
+	// +optional
+	// VEX document (OpenVEX, CycloneDX or CSAF) marking vulnerabilities as not affected
+	vex *dagger.File,
+) (*ScanReport, error) {
+	out, err := m.ScanDirectory(ctx, source, severity, 0, ScanFormatJson, ignoreFile, vex)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.report(ctx, out, ignoreFile)
+}
+
+// ScanConfigReport scans IaC files and returns a parsed report of misconfigurations
+func (m *Trivy) ScanConfigReport(
+	ctx context.Context,
+	source *dagger.Directory,
+	// +optional
+	// +default="UNKNOWN,LOW,MEDIUM,HIGH,CRITICAL"
+	severity string,
+	// +optional
+	// Trivy ignore file (.trivyignore or .trivyignore.yaml)
+	ignoreFile *dagger.File,
+) (*ScanReport, error) {
+	out, err := m.ScanConfig(ctx, source, severity, 0, ScanFormatJson, ignoreFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.report(ctx, out, ignoreFile)
+}
+
+// ScanSbomReport scans an existing SBOM and returns a parsed report
+func (m *Trivy) ScanSbomReport(
+	ctx context.Context,
+	sbom *dagger.File,
+	// +optional
+	// +default="UNKNOWN,LOW,MEDIUM,HIGH,CRITICAL"
+	severity string,
+	// +optional
+	// Trivy ignore file (.trivyignore or .trivyignore.yaml)
+	ignoreFile *dagger.File,
+	// +optional
+	// VEX document (OpenVEX, CycloneDX or CSAF) marking vulnerabilities as not affected
+	vex *dagger.File,
+) (*ScanReport, error) {
+	out, err := m.ScanSbom(ctx, sbom, severity, 0, ScanFormatJson, ignoreFile, vex)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.report(ctx, out, ignoreFile)
+}
+
+// ScanContainers scans all platform variants of an image concurrently and returns a merged report
+//
+// Each finding records the platform it came from and is listed once per
+// platform, but the severity counts Gate checks include a vulnerability in
+// the same package only once, however many platforms it is found on.
+func (m *Trivy) ScanContainers(
+	ctx context.Context,
+	// Platform variants to scan (e.g., the PlatformVariants passed to Publish)
+	ctrs []*dagger.Container,
+	// +optional
+	// +default="UNKNOWN,LOW,MEDIUM,HIGH,CRITICAL"
+	severity string,
+	// +optional
+	// Trivy ignore file (.trivyignore or .trivyignore.yaml)
+	ignoreFile *dagger.File,
+	// +optional
+	// VEX document (OpenVEX, CycloneDX or CSAF) marking vulnerabilities as not affected
+	vex *dagger.File,
+) (*ScanReport, error) {
+	reports := make([]*ScanReport, len(ctrs))
+
+	eg, gctx := errgroup.WithContext(ctx)
+	for i, ctr := range ctrs {
+		eg.Go(func() error {
+			platform, err := ctr.Platform(gctx)
+			if err != nil {
+				return err
+			}
+
+			imageRef := "scan-" + strings.ReplaceAll(string(platform), "/", "-")
+			report, err := m.ScanContainerReport(gctx, ctr, imageRef, severity, ignoreFile, vex)
+			if err != nil {
+				return fmt.Errorf("scan of %s failed: %w", platform, err)
+			}
+
+			for _, finding := range report.Findings {
+				finding.Platform = string(platform)
+			}
+			reports[i] = report
+			return nil
+		})
+	}
+
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+
+	merged := &ScanReport{}
+	for _, report := range reports {
+		merged.merge(report)
+	}
+
+	// Every platform was scanned with the same ignore file, so report its warnings once
+	warnings, err := m.ignoreWarnings(ctx, ignoreFile)
+	if err != nil {
+		return nil, err
+	}
+	merged.Warnings = warnings
+
+	return merged, nil
 }
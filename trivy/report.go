@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ScanReport is a parsed Trivy scan result
+//
+// Severity counts are of unique findings: a CVE in the same package on
+// several platforms counts once, while Findings lists it for each platform.
+type ScanReport struct {
+	// Number of CRITICAL findings
+	Critical int
+	// Number of HIGH findings
+	High int
+	// Number of MEDIUM findings
+	Medium int
+	// Number of LOW findings
+	Low int
+	// Number of UNKNOWN findings
+	Unknown int
+	// All findings in the scan
+	Findings []*Finding
+	// Problems with the scan inputs, such as expired ignore file entries
+	Warnings []string
+
+	counted map[string]bool
+}
+
+// Finding is a single vulnerability, misconfiguration or secret reported by Trivy
+type Finding struct {
+	// Vulnerability, misconfiguration or secret rule ID (e.g., "CVE-2024-1234", "AVD-AWS-0086")
+	Id string
+	// Severity as reported by Trivy (e.g., "CRITICAL")
+	Severity string
+	// Kind of finding: "vulnerability", "misconfiguration" or "secret"
+	Kind string
+	// Affected package name, empty for misconfigurations and secrets
+	Package string
+	// Installed package version
+	InstalledVersion string
+	// Version that fixes the vulnerability, empty if no fix is available
+	FixedVersion string
+	// Short description of the finding
+	Title string
+	// Scan target the finding belongs to (e.g., "scan-target (alpine 3.21.3)")
+	Target string
+	// Platform of the scanned container (e.g., "linux/arm64"), set by ScanContainers
+	Platform string
+}
+
+// trivyOutput is the subset of Trivy's JSON output we care about
+type trivyOutput struct {
+	Results []struct {
+		Target          string `json:"Target"`
+		Vulnerabilities []struct {
+			VulnerabilityID  string `json:"VulnerabilityID"`
+			PkgName          string `json:"PkgName"`
+			InstalledVersion string `json:"InstalledVersion"`
+			FixedVersion     string `json:"FixedVersion"`
+			Severity         string `json:"Severity"`
+			Title            string `json:"Title"`
+		} `json:"Vulnerabilities"`
+		Misconfigurations []struct {
+			ID       string `json:"ID"`
+			Title    string `json:"Title"`
+			Severity string `json:"Severity"`
+			Status   string `json:"Status"`
+		} `json:"Misconfigurations"`
+		Secrets []struct {
+			RuleID   string `json:"RuleID"`
+			Title    string `json:"Title"`
+			Severity string `json:"Severity"`
+		} `json:"Secrets"`
+	} `json:"Results"`
+}
+
+// parseReport builds a ScanReport from Trivy's JSON output
+func parseReport(raw string) (*ScanReport, error) {
+	var out trivyOutput
+	if err := json.Unmarshal([]byte(raw), &out); err != nil {
+		return nil, fmt.Errorf("failed to parse trivy output: %w", err)
+	}
+
+	report := &ScanReport{}
+	for _, result := range out.Results {
+		for _, vuln := range result.Vulnerabilities {
+			report.add(&Finding{
+				Id:               vuln.VulnerabilityID,
+				Severity:         vuln.Severity,
+				Kind:             "vulnerability",
+				Package:          vuln.PkgName,
+				InstalledVersion: vuln.InstalledVersion,
+				FixedVersion:     vuln.FixedVersion,
+				Title:            vuln.Title,
+				Target:           result.Target,
+			})
+		}
+
+		for _, misconf := range result.Misconfigurations {
+			// Passed checks are only present with --include-non-failures
+			if misconf.Status != "" && misconf.Status != "FAIL" {
+				continue
+			}
+			report.add(&Finding{
+				Id:       misconf.ID,
+				Severity: misconf.Severity,
+				Kind:     "misconfiguration",
+				Title:    misconf.Title,
+				Target:   result.Target,
+			})
+		}
+
+		for _, secret := range result.Secrets {
+			report.add(&Finding{
+				Id:       secret.RuleID,
+				Severity: secret.Severity,
+				Kind:     "secret",
+				Title:    secret.Title,
+				Target:   result.Target,
+			})
+		}
+	}
+
+	return report, nil
+}
+
+// key identifies a finding independently of the platform it was found on
+func (f *Finding) key() string {
+	if f.Kind == "vulnerability" {
+		return f.Kind + " " + f.Id + " " + f.Package
+	}
+	// Misconfigurations and secrets have no package, and the same rule can fail in several files
+	return f.Kind + " " + f.Id + " " + f.Target
+}
+
+// add appends a finding and updates the severity counts if it was not counted yet
+func (r *ScanReport) add(finding *Finding) {
+	r.Findings = append(r.Findings, finding)
+
+	if r.counted == nil {
+		r.counted = map[string]bool{}
+	}
+	if r.counted[finding.key()] {
+		return
+	}
+	r.counted[finding.key()] = true
+
+	switch finding.Severity {
+	case "CRITICAL":
+		r.Critical++
+	case "HIGH":
+		r.High++
+	case "MEDIUM":
+		r.Medium++
+	case "LOW":
+		r.Low++
+	default:
+		r.Unknown++
+	}
+}
+
+// merge adds all findings from another report
+func (r *ScanReport) merge(other *ScanReport) {
+	for _, finding := range other.Findings {
+		r.add(finding)
+	}
+}
+
+// Summary returns a one-line summary of the severity counts
+func (r *ScanReport) Summary() string {
+	return fmt.Sprintf("CRITICAL: %d, HIGH: %d, MEDIUM: %d, LOW: %d, UNKNOWN: %d",
+		r.Critical, r.High, r.Medium, r.Low, r.Unknown)
+}
+
+// Gate returns an error naming the offending CVEs if the report exceeds the allowed counts
+//
+// A negative limit disables the check for that severity.
+func (r *ScanReport) Gate(
+	// Maximum number of CRITICAL findings allowed
+	maxCritical int,
+	// Maximum number of HIGH findings allowed
+	maxHigh int,
+) error {
+	var violations []string
+
+	if maxCritical >= 0 && r.Critical > maxCritical {
+		violations = append(violations, fmt.Sprintf("%d CRITICAL (max %d): %s",
+			r.Critical, maxCritical, strings.Join(r.ids("CRITICAL"), ", ")))
+	}
+
+	if maxHigh >= 0 && r.High > maxHigh {
+		violations = append(violations, fmt.Sprintf("%d HIGH (max %d): %s",
+			r.High, maxHigh, strings.Join(r.ids("HIGH"), ", ")))
+	}
+
+	if len(violations) > 0 {
+		return fmt.Errorf("scan policy violated: %s", strings.Join(violations, "; "))
+	}
+
+	return nil
+}
+
+// ids returns the unique finding IDs with the given severity
+func (r *ScanReport) ids(severity string) []string {
+	seen := map[string]bool{}
+	var ids []string
+	for _, f := range r.Findings {
+		if f.Severity == severity && !seen[f.Id] {
+			seen[f.Id] = true
+			ids = append(ids, f.Id)
+		}
+	}
+	return ids
+}
+
+// expiredIgnores returns a warning for every ignore file entry whose expiry date has passed
+//
+// Trivy stops suppressing expired entries, so the findings reappear in the
+// report. Supports "CVE-ID exp:YYYY-MM-DD" lines in .trivyignore and
+// "expired_at: YYYY-MM-DD" keys in .trivyignore.yaml.
+func expiredIgnores(name, contents string, now time.Time) []string {
+	var entries []ignoreEntry
+	if strings.HasSuffix(name, ".yaml") || strings.HasSuffix(name, ".yml") {
+		entries = yamlIgnoreEntries(contents)
+	} else {
+		entries = plainIgnoreEntries(contents)
+	}
+
+	var warnings []string
+	for _, entry := range entries {
+		if entry.expiry == "" {
+			continue
+		}
+
+		expiredAt, err := time.Parse(time.DateOnly, entry.expiry)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: invalid expiry date %q for %s", name, entry.expiry, entry.id))
+			continue
+		}
+
+		if now.After(expiredAt) {
+			warnings = append(warnings, fmt.Sprintf("%s: ignore entry for %s expired on %s and no longer suppresses findings",
+				name, entry.id, entry.expiry))
+		}
+	}
+
+	return warnings
+}
+
+// ignoreEntry is the ID and expiry date of an ignore file entry
+type ignoreEntry struct {
+	id     string
+	expiry string
+}
+
+// plainIgnoreEntries parses the "CVE-ID exp:YYYY-MM-DD" lines of a .trivyignore
+func plainIgnoreEntries(contents string) []ignoreEntry {
+	var entries []ignoreEntry
+	for _, line := range strings.Split(contents, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		entry := ignoreEntry{id: fields[0]}
+		for _, field := range fields[1:] {
+			if strings.HasPrefix(field, "exp:") {
+				entry.expiry = strings.TrimPrefix(field, "exp:")
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// yamlIgnoreEntries parses the list entries of a .trivyignore.yaml
+//
+// An entry starts at a list item and ends at the next line indented less than
+// its keys, so its keys can come in any order. Nested lists such as paths are
+// skipped.
+func yamlIgnoreEntries(contents string) []ignoreEntry {
+	var entries []ignoreEntry
+	// Column of the keys of the current entry, -1 outside of an entry
+	keyIndent := -1
+	for _, raw := range strings.Split(contents, "\n") {
+		line := strings.TrimSpace(raw)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		indent := len(raw) - len(strings.TrimLeft(raw, " "))
+
+		if indent < keyIndent {
+			keyIndent = -1
+		}
+
+		if keyIndent < 0 && strings.HasPrefix(line, "- ") {
+			entries = append(entries, ignoreEntry{})
+			item := strings.TrimPrefix(strings.TrimLeft(raw, " "), "-")
+			keyIndent = indent + 1 + len(item) - len(strings.TrimLeft(item, " "))
+			line = strings.TrimSpace(item)
+		} else if indent != keyIndent {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(strings.TrimSpace(value), `"'`)
+
+		entry := &entries[len(entries)-1]
+		switch strings.TrimSpace(key) {
+		case "id":
+			entry.id = value
+		case "expired_at":
+			entry.expiry = value
+		}
+	}
+	return entries
+}